@@ -0,0 +1,193 @@
+package epd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	BarcodeCode128 = "code128"
+	BarcodeEAN13   = "ean13"
+)
+
+// Barcode is an encoded one dimensional barcode
+type Barcode struct {
+	// Modules are the bars and spaces of the code, true for a
+	// bar, one module wide each. There's no quiet zone.
+	Modules []bool
+}
+
+// EncodeBarcode encodes text in a symbology,
+// code128 (default) or ean13
+func EncodeBarcode(text, symbology string) (Barcode, error) {
+	switch strings.Replace(strings.ToLower(symbology), "-", "", -1) {
+	case BarcodeEAN13:
+		return EncodeEAN13(text)
+	case "", BarcodeCode128:
+		return EncodeCode128(text)
+	}
+	return Barcode{}, fmt.Errorf("Unknown barcode symbology %s", symbology)
+}
+
+// appendWidths appends alternating bars and spaces of
+// the widths given as digits, starting with a bar
+func (b *Barcode) appendWidths(widths string) {
+	bar := true
+	for _, w := range widths {
+		for i := 0; i < int(w-'0'); i++ {
+			b.Modules = append(b.Modules, bar)
+		}
+		bar = !bar
+	}
+}
+
+// appendBits appends modules given as 1s for bars and 0s for spaces
+func (b *Barcode) appendBits(bits string) {
+	for _, bit := range bits {
+		b.Modules = append(b.Modules, bit == '1')
+	}
+}
+
+// code128Patterns are the bar and space widths of each
+// Code 128 symbol value
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232",
+}
+
+const (
+	code128CodeB  = 100
+	code128CodeC  = 99
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = "2331112"
+)
+
+// EncodeCode128 encodes printable ASCII text as Code 128. Runs
+// of digits are packed two to a symbol.
+func EncodeCode128(text string) (code Barcode, err error) {
+
+	if text == "" {
+		return code, errors.New("Nothing to encode")
+	}
+	for _, c := range text {
+		if c < 32 || c > 126 {
+			return code, fmt.Errorf("Can't encode %q in code128", c)
+		}
+	}
+
+	digitRun := func(from int) int {
+		n := from
+		for n < len(text) && text[n] >= '0' && text[n] <= '9' {
+			n++
+		}
+		return n - from
+	}
+
+	var values []int
+	codeC := false
+	// Code C is worth it for 4 or more digits, or for
+	// a whole code of 2 or more
+	if run := digitRun(0); run >= 4 || (run == len(text) && run%2 == 0) {
+		values = append(values, code128StartC)
+		codeC = true
+	} else {
+		values = append(values, code128StartB)
+	}
+
+	for i := 0; i < len(text); {
+		run := digitRun(i)
+		switch {
+		case codeC && run >= 2:
+			values = append(values, int(text[i]-'0')*10+int(text[i+1]-'0'))
+			i += 2
+			continue
+		case codeC:
+			values = append(values, code128CodeB)
+			codeC = false
+		case run >= 6 || (run >= 4 && i+run == len(text)):
+			// Switch to code C for long runs of digits, leaving
+			// an odd digit out in code B
+			if run%2 == 1 {
+				values = append(values, int(text[i])-32)
+				i++
+			}
+			values = append(values, code128CodeC)
+			codeC = true
+			continue
+		}
+		values = append(values, int(text[i])-32)
+		i++
+	}
+
+	checksum := values[0]
+	for i, v := range values[1:] {
+		checksum += (i + 1) * v
+	}
+	values = append(values, checksum%103)
+
+	for _, v := range values {
+		code.appendWidths(code128Patterns[v])
+	}
+	code.appendWidths(code128Stop)
+	return
+}
+
+var (
+	eanL = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanG = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	eanR = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// eanParity is which of the left digits use the G codes,
+	// picked by the first digit
+	eanParity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLG", "LGLGGL", "LGLGLG", "LGGLGL"}
+)
+
+// EncodeEAN13 encodes 12 digits, or 13 with the check digit,
+// as an EAN-13 barcode
+func EncodeEAN13(text string) (code Barcode, err error) {
+
+	digits := strings.Replace(text, " ", "", -1)
+	if len(digits) != 12 && len(digits) != 13 || strings.Trim(digits, "0123456789") != "" {
+		return code, fmt.Errorf("EAN-13 needs 12 or 13 digits, got %q", text)
+	}
+
+	sum := 0
+	for i, d := range digits[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	check := byte('0' + (10-sum%10)%10)
+	if len(digits) == 13 && digits[12] != check {
+		return code, fmt.Errorf("EAN-13 check digit of %s should be %c", text, check)
+	}
+	digits = digits[:12] + string(check)
+
+	parity := eanParity[digits[0]-'0']
+	code.appendBits("101")
+	for i, d := range digits[1:7] {
+		if parity[i] == 'G' {
+			code.appendBits(eanG[d-'0'])
+		} else {
+			code.appendBits(eanL[d-'0'])
+		}
+	}
+	code.appendBits("01010")
+	for _, d := range digits[7:] {
+		code.appendBits(eanR[d-'0'])
+	}
+	code.appendBits("101")
+	return
+}
@@ -0,0 +1,121 @@
+package epd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// code128Values reads the symbol values back out of a Code 128
+// barcode, checking it ends with the stop pattern
+func code128Values(t *testing.T, code Barcode) (values []int) {
+	t.Helper()
+	var stop Barcode
+	stop.appendWidths(code128Stop)
+	end := len(code.Modules) - len(stop.Modules)
+	if end < 0 || end%11 != 0 || !reflect.DeepEqual(code.Modules[end:], stop.Modules) {
+		t.Fatalf("Expected symbols of 11 modules and a stop pattern, got %d modules", len(code.Modules))
+	}
+	for i := 0; i < end; i += 11 {
+		value := -1
+		for v, pattern := range code128Patterns {
+			var symbol Barcode
+			symbol.appendWidths(pattern)
+			if reflect.DeepEqual(code.Modules[i:i+11], symbol.Modules) {
+				value = v
+			}
+		}
+		if value < 0 {
+			t.Fatalf("Unknown symbol at module %d", i)
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		text string
+		// values are the start, data and checksum symbols
+		values []int
+	}{
+		{"PJJ123C", []int{code128StartB, 48, 42, 42, 17, 18, 19, 35, 55}},
+		{"12345678", []int{code128StartC, 12, 34, 56, 78, 47}},
+		{"AB12345", []int{code128StartB, 33, 34, 17, code128CodeC, 23, 45, 7}},
+		{"1234A", []int{code128StartC, 12, 34, code128CodeB, 33, 102}},
+	}
+	for _, test := range tests {
+		code, err := EncodeCode128(test.text)
+		if err != nil {
+			t.Errorf("Encoding %s: %s", test.text, err.Error())
+			continue
+		}
+		if values := code128Values(t, code); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("Expected %s to be symbols %v, got %v", test.text, test.values, values)
+		}
+	}
+
+	for _, text := range []string{"", "tab\there", "café"} {
+		if _, err := EncodeCode128(text); err == nil {
+			t.Errorf("Expected an error encoding %q", text)
+		}
+	}
+}
+
+func TestEncodeEAN13(t *testing.T) {
+	// 5 picks the L G G L L G parity for the left digits
+	want := "101" +
+		"0001011" + "0100111" + "0110011" + "0010011" + "0111101" + "0011101" +
+		"01010" +
+		"1100110" + "1101100" + "1000010" + "1011100" + "1001110" + "1000100" +
+		"101"
+	for _, text := range []string{"590123412345", "5901234123457", "590 1234 12345 7"} {
+		code, err := EncodeEAN13(text)
+		if err != nil {
+			t.Errorf("Encoding %s: %s", text, err.Error())
+			continue
+		}
+		var got strings.Builder
+		for _, bar := range code.Modules {
+			if bar {
+				got.WriteByte('1')
+			} else {
+				got.WriteByte('0')
+			}
+		}
+		if got.String() != want {
+			t.Errorf("Expected %s to be\n%s, got\n%s", text, want, got.String())
+		}
+	}
+
+	tests := []struct {
+		text, err string
+	}{
+		{"5901234123458", "EAN-13 check digit of 5901234123458 should be 7"},
+		{"400638133393", ""},
+		{"4006381333931", ""},
+		{"4006381333930", "EAN-13 check digit of 4006381333930 should be 1"},
+		{"59012341234", "EAN-13 needs 12 or 13 digits, got \"59012341234\""},
+		{"59012341234X", "EAN-13 needs 12 or 13 digits, got \"59012341234X\""},
+	}
+	for _, test := range tests {
+		_, err := EncodeEAN13(test.text)
+		if test.err == "" && err != nil {
+			t.Errorf("Encoding %s: %s", test.text, err.Error())
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Expected %q encoding %s, got %v", test.err, test.text, err)
+		}
+	}
+}
+
+func TestEncodeBarcode(t *testing.T) {
+	for _, symbology := range []string{"", "code128", "Code-128", "EAN13", "ean-13"} {
+		if _, err := EncodeBarcode("400638133393", symbology); err != nil {
+			t.Errorf("Encoding as %q: %s", symbology, err.Error())
+		}
+	}
+	if _, err := EncodeBarcode("400638133393", "upc"); err == nil {
+		t.Error("Expected an error for an unknown symbology")
+	}
+}
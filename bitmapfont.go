@@ -0,0 +1,662 @@
+package epd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// BitmapFont is a fixed size font of 1-bit glyphs, such as
+// the BDF and PCF fonts used by X11 and terminals. Glyphs
+// are only ever scaled by whole numbers so they stay crisp.
+type BitmapFont struct {
+	Name string
+	// PixelSize is the height of the font's em in pixels
+	PixelSize int
+	Ascent    int
+	Descent   int
+	glyphs    map[rune]*bitmapGlyph
+	// fallback is drawn for runes the font doesn't have
+	fallback *bitmapGlyph
+}
+
+// bitmapGlyph is a glyph's bitmap and metrics in pixels.
+// The bitmap's origin is the glyph's origin on the baseline,
+// so its bounds are usually negative in y.
+type bitmapGlyph struct {
+	mask    *image.Alpha
+	advance int
+}
+
+// Face returns a face of the font scaled by the whole number
+// closest to size at dpi, and at least 1.
+func (f *BitmapFont) Face(size, dpi float64) font.Face {
+	scale := 1
+	if size > 0 && f.PixelSize > 0 {
+		pixels := size * dpi / 72
+		scale = int(math.Max(1, math.Round(pixels/float64(f.PixelSize))))
+	}
+	return &bitmapFace{font: f, scale: scale}
+}
+
+func (f *BitmapFont) glyph(r rune) (g *bitmapGlyph, ok bool) {
+	if g, ok = f.glyphs[r]; ok {
+		return
+	}
+	return f.fallback, f.fallback != nil
+}
+
+// bitmapFace is a font.Face for a BitmapFont at a scale
+type bitmapFace struct {
+	font  *BitmapFont
+	scale int
+}
+
+func (f *bitmapFace) Close() error {
+	return nil
+}
+
+func (f *bitmapFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	g, ok := f.font.glyph(r)
+	if !ok {
+		return
+	}
+	glyphMask := g.mask
+	if f.scale > 1 {
+		glyphMask = scaleMask(g.mask, f.scale)
+	}
+	// Snap to whole pixels to keep the glyph crisp
+	dr = glyphMask.Bounds().Add(image.Point{X: dot.X.Round(), Y: dot.Y.Round()})
+	mask = glyphMask
+	maskp = glyphMask.Bounds().Min
+	advance = fixed.I(g.advance * f.scale)
+	return
+}
+
+func (f *bitmapFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	g, ok := f.font.glyph(r)
+	if !ok {
+		return
+	}
+	b := g.mask.Bounds()
+	bounds = fixed.R(b.Min.X*f.scale, b.Min.Y*f.scale, b.Max.X*f.scale, b.Max.Y*f.scale)
+	advance = fixed.I(g.advance * f.scale)
+	return
+}
+
+func (f *bitmapFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	g, ok := f.font.glyph(r)
+	if !ok {
+		return
+	}
+	return fixed.I(g.advance * f.scale), true
+}
+
+func (f *bitmapFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	return 0
+}
+
+func (f *bitmapFace) Metrics() font.Metrics {
+	return font.Metrics{
+		Height:  fixed.I((f.font.Ascent + f.font.Descent) * f.scale),
+		Ascent:  fixed.I(f.font.Ascent * f.scale),
+		Descent: fixed.I(f.font.Descent * f.scale),
+	}
+}
+
+// scaleMask scales a glyph mask up by a whole number
+func scaleMask(mask *image.Alpha, scale int) *image.Alpha {
+	b := mask.Bounds()
+	scaled := image.NewAlpha(image.Rectangle{Min: b.Min.Mul(scale), Max: b.Max.Mul(scale)})
+	for y := scaled.Rect.Min.Y; y < scaled.Rect.Max.Y; y++ {
+		for x := scaled.Rect.Min.X; x < scaled.Rect.Max.X; x++ {
+			scaled.SetAlpha(x, y, mask.AlphaAt(floorDiv(x, scale), floorDiv(y, scale)))
+		}
+	}
+	return scaled
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// ParseBDF parses a font in Glyph Bitmap Distribution Format.
+// Encodings are taken to be unicode code points, which holds
+// for ISO10646 and ISO8859-1 fonts.
+func ParseBDF(data []byte) (f *BitmapFont, err error) {
+
+	f = &BitmapFont{glyphs: make(map[rune]*bitmapGlyph)}
+	defaultChar := -1
+	var boundsW, boundsH, boundsX, boundsY int
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	next := func() (keyword string, args []string, ok bool) {
+		for scanner.Scan() {
+			lineNo++
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			return fields[0], fields[1:], true
+		}
+		return "", nil, false
+	}
+	ints := func(args []string, n int) (values []int, err error) {
+		if len(args) < n {
+			return nil, fmt.Errorf("BDF line %d: expected %d values", lineNo, n)
+		}
+		for _, arg := range args[:n] {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, fmt.Errorf("BDF line %d: %s", lineNo, err.Error())
+			}
+			values = append(values, v)
+		}
+		return
+	}
+
+	keyword, _, ok := next()
+	if !ok || keyword != "STARTFONT" {
+		return nil, errors.New("Not a BDF font")
+	}
+
+	for {
+		keyword, args, ok := next()
+		if !ok || keyword == "ENDFONT" {
+			break
+		}
+		var v []int
+		switch keyword {
+		case "FONT":
+			f.Name = strings.Join(args, " ")
+		case "PIXEL_SIZE":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			f.PixelSize = v[0]
+		case "FONTBOUNDINGBOX":
+			if v, err = ints(args, 4); err != nil {
+				return
+			}
+			boundsW, boundsH, boundsX, boundsY = v[0], v[1], v[2], v[3]
+		case "FONT_ASCENT":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			f.Ascent = v[0]
+		case "FONT_DESCENT":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			f.Descent = v[0]
+		case "DEFAULT_CHAR":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			defaultChar = v[0]
+		case "STARTCHAR":
+			var code int
+			var g *bitmapGlyph
+			if code, g, err = parseBDFChar(next, ints, boundsW, boundsH, boundsX, boundsY); err != nil {
+				return
+			}
+			if code >= 0 {
+				f.glyphs[rune(code)] = g
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if len(f.glyphs) == 0 {
+		return nil, errors.New("BDF font has no glyphs")
+	}
+	if f.Ascent == 0 && f.Descent == 0 {
+		f.Ascent = boundsH + boundsY
+		f.Descent = -boundsY
+	}
+	if f.PixelSize == 0 {
+		f.PixelSize = f.Ascent + f.Descent
+	}
+	f.fallback = f.glyphs[rune(defaultChar)]
+	if f.fallback == nil {
+		f.fallback = f.glyphs['?']
+	}
+	return
+}
+
+func parseBDFChar(
+	next func() (string, []string, bool),
+	ints func([]string, int) ([]int, error),
+	w, h, x, y int,
+) (code int, g *bitmapGlyph, err error) {
+
+	g = &bitmapGlyph{advance: w}
+	code = -1
+
+	for {
+		keyword, args, ok := next()
+		if !ok {
+			return code, nil, errors.New("BDF font ends mid glyph")
+		}
+		var v []int
+		switch keyword {
+		case "ENCODING":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			code = v[0]
+			// Glyphs with no standard encoding give their
+			// font specific code as a second value
+			if code < 0 {
+				code = -1
+			}
+		case "DWIDTH":
+			if v, err = ints(args, 1); err != nil {
+				return
+			}
+			g.advance = v[0]
+		case "BBX":
+			if v, err = ints(args, 4); err != nil {
+				return
+			}
+			w, h, x, y = v[0], v[1], v[2], v[3]
+		case "BITMAP":
+			g.mask = image.NewAlpha(image.Rect(x, -y-h, x+w, -y))
+			for row := 0; row < h; row++ {
+				hexRow, _, ok := next()
+				if !ok {
+					return code, nil, errors.New("BDF font ends mid bitmap")
+				}
+				bits, errr := hex.DecodeString(hexRow)
+				if errr != nil {
+					return code, nil, fmt.Errorf("BDF bitmap: %s", errr.Error())
+				}
+				for col := 0; col < w && col/8 < len(bits); col++ {
+					if bits[col/8]&(0x80>>uint(col%8)) != 0 {
+						g.mask.Pix[row*g.mask.Stride+col] = 0xFF
+					}
+				}
+			}
+		case "ENDCHAR":
+			if g.mask == nil {
+				g.mask = image.NewAlpha(image.Rect(0, 0, 0, 0))
+			}
+			return
+		}
+	}
+}
+
+// PCF table types and format flags
+const (
+	pcfProperties      = 1 << 0
+	pcfAccelerators    = 1 << 1
+	pcfMetrics         = 1 << 2
+	pcfBitmaps         = 1 << 3
+	pcfBDFEncodings    = 1 << 5
+	pcfBDFAccelerators = 1 << 8
+
+	pcfFormatMask        = 0xFFFFFF00
+	pcfCompressedMetrics = 0x00000100
+	pcfGlyphPadMask      = 3 << 0
+	pcfByteMask          = 1 << 2
+	pcfBitMask           = 1 << 3
+	pcfScanUnitMask      = 3 << 4
+	pcfDefaultFormat     = 0x00000000
+)
+
+type pcfTable struct {
+	format uint32
+	size   uint32
+	offset uint32
+}
+
+// pcfReader reads the values of a pcf table in its byte order
+type pcfReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	err   error
+}
+
+func newPCFReader(data []byte, table pcfTable) (r *pcfReader, format uint32, err error) {
+	// Offsets and sizes are added as uint64 so they can't overflow
+	end := uint64(table.offset) + uint64(table.size)
+	if table.size < 4 || end > uint64(len(data)) {
+		return nil, 0, errors.New("PCF table out of range")
+	}
+	tableData := data[table.offset:end]
+	// The format is always little endian, the rest of
+	// the table is in the byte order the format gives
+	format = binary.LittleEndian.Uint32(tableData)
+	r = &pcfReader{data: tableData, pos: 4, order: binary.LittleEndian}
+	if format&pcfByteMask != 0 {
+		r.order = binary.BigEndian
+	}
+	return
+}
+
+func (r *pcfReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.pos {
+		if r.err == nil {
+			r.err = errors.New("PCF table truncated")
+		}
+		// Values read after an error are zero
+		if n < 0 || n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// count reads a count of items of size bytes each, which must
+// fit in what's left of the table
+func (r *pcfReader) count(n, size int) int {
+	if r.err == nil && (n < 0 || n > (len(r.data)-r.pos)/size) {
+		r.err = fmt.Errorf("PCF table count %d out of range", n)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *pcfReader) uint8() int {
+	return int(r.bytes(1)[0])
+}
+
+func (r *pcfReader) int16() int {
+	return int(int16(r.order.Uint16(r.bytes(2))))
+}
+
+func (r *pcfReader) uint16() int {
+	return int(r.order.Uint16(r.bytes(2)))
+}
+
+func (r *pcfReader) int32() int {
+	return int(int32(r.order.Uint32(r.bytes(4))))
+}
+
+type pcfMetric struct {
+	left, right, width, ascent, descent int
+}
+
+// ParsePCF parses an X11 Portable Compiled Format font.
+// Encodings are taken to be unicode code points, which holds
+// for ISO10646 and ISO8859-1 fonts.
+func ParsePCF(data []byte) (f *BitmapFont, err error) {
+
+	if len(data) < 8 || string(data[:4]) != "\x01fcp" {
+		return nil, errors.New("Not a PCF font")
+	}
+
+	count := binary.LittleEndian.Uint32(data[4:])
+	if uint64(count) > uint64(len(data)-8)/16 {
+		return nil, errors.New("PCF table of contents truncated")
+	}
+	tables := make(map[uint32]pcfTable)
+	for i := 0; i < int(count); i++ {
+		entry := data[8+i*16:]
+		tables[binary.LittleEndian.Uint32(entry)] = pcfTable{
+			format: binary.LittleEndian.Uint32(entry[4:]),
+			size:   binary.LittleEndian.Uint32(entry[8:]),
+			offset: binary.LittleEndian.Uint32(entry[12:]),
+		}
+	}
+
+	for _, required := range []uint32{pcfMetrics, pcfBitmaps, pcfBDFEncodings} {
+		if _, ok := tables[required]; !ok {
+			return nil, fmt.Errorf("PCF font missing table %d", required)
+		}
+	}
+
+	metrics, err := parsePCFMetrics(data, tables[pcfMetrics])
+	if err != nil {
+		return
+	}
+
+	bitmaps, err := parsePCFBitmaps(data, tables[pcfBitmaps], metrics)
+	if err != nil {
+		return
+	}
+
+	f = &BitmapFont{glyphs: make(map[rune]*bitmapGlyph)}
+
+	defaultChar, err := parsePCFEncodings(data, tables[pcfBDFEncodings], func(code rune, idx int) {
+		if idx < len(bitmaps) {
+			f.glyphs[code] = bitmaps[idx]
+		}
+	})
+	if err != nil {
+		return
+	}
+	f.fallback = f.glyphs[defaultChar]
+	if f.fallback == nil {
+		f.fallback = f.glyphs['?']
+	}
+
+	accel, ok := tables[pcfBDFAccelerators]
+	if !ok {
+		accel, ok = tables[pcfAccelerators]
+	}
+	if ok {
+		var r *pcfReader
+		if r, _, err = newPCFReader(data, accel); err != nil {
+			return
+		}
+		// Skip the eight flags
+		r.bytes(8)
+		f.Ascent = r.int32()
+		f.Descent = r.int32()
+		if r.err != nil {
+			return nil, r.err
+		}
+	} else {
+		for _, m := range metrics {
+			f.Ascent = int(math.Max(float64(f.Ascent), float64(m.ascent)))
+			f.Descent = int(math.Max(float64(f.Descent), float64(m.descent)))
+		}
+	}
+	f.PixelSize = f.Ascent + f.Descent
+
+	if props, ok := tables[pcfProperties]; ok {
+		name, pixelSize := parsePCFProperties(data, props)
+		f.Name = name
+		if pixelSize > 0 {
+			f.PixelSize = pixelSize
+		}
+	}
+
+	return
+}
+
+func parsePCFMetrics(data []byte, table pcfTable) (metrics []pcfMetric, err error) {
+	r, format, err := newPCFReader(data, table)
+	if err != nil {
+		return
+	}
+	if format&pcfFormatMask == pcfCompressedMetrics {
+		count := r.count(r.int16(), 5)
+		for i := 0; i < count && r.err == nil; i++ {
+			metrics = append(metrics, pcfMetric{
+				left:    r.uint8() - 0x80,
+				right:   r.uint8() - 0x80,
+				width:   r.uint8() - 0x80,
+				ascent:  r.uint8() - 0x80,
+				descent: r.uint8() - 0x80,
+			})
+		}
+	} else {
+		count := r.count(r.int32(), 12)
+		for i := 0; i < count && r.err == nil; i++ {
+			metrics = append(metrics, pcfMetric{
+				left:    r.int16(),
+				right:   r.int16(),
+				width:   r.int16(),
+				ascent:  r.int16(),
+				descent: r.int16(),
+			})
+			// Attributes
+			r.int16()
+		}
+	}
+	return metrics, r.err
+}
+
+func parsePCFBitmaps(data []byte, table pcfTable, metrics []pcfMetric) (glyphs []*bitmapGlyph, err error) {
+	r, format, err := newPCFReader(data, table)
+	if err != nil {
+		return
+	}
+	count := r.count(r.int32(), 4)
+	offsets := make([]int, count)
+	for i := range offsets {
+		offsets[i] = r.int32()
+	}
+	var sizes [4]int
+	for i := range sizes {
+		sizes[i] = r.int32()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	pad := 1 << (format & pcfGlyphPadMask)
+	unit := 1 << ((format & pcfScanUnitMask) >> 4)
+	msbBytes := format&pcfByteMask != 0
+	msbBits := format&pcfBitMask != 0
+	bits := r.bytes(sizes[format&pcfGlyphPadMask])
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for i := 0; i < count && i < len(metrics); i++ {
+		m := metrics[i]
+		w, h := m.right-m.left, m.ascent+m.descent
+		bounds := image.Rect(m.left, -m.ascent, m.right, m.descent)
+		if w < 0 || h < 0 {
+			w, h, bounds = 0, 0, image.Rectangle{}
+		}
+		stride := (w + pad*8 - 1) / (pad * 8) * pad
+		// A glyph can't have more rows than there are bitmaps
+		if h > 0 && stride > 0 && h > len(bits)/stride {
+			return nil, fmt.Errorf("PCF glyph %d larger than the bitmaps", i)
+		}
+		g := &bitmapGlyph{
+			mask:    image.NewAlpha(bounds),
+			advance: m.width,
+		}
+		for row := 0; row < h; row++ {
+			for col := 0; col < w; col++ {
+				idx := offsets[i] + row*stride + col/8
+				// Swap bytes within each scan unit when the byte
+				// and bit orders disagree
+				if msbBytes != msbBits && unit > 1 {
+					base := idx - idx%unit
+					idx = base + unit - 1 - idx%unit
+				}
+				if idx < 0 || idx >= len(bits) {
+					continue
+				}
+				mask := byte(0x80 >> uint(col%8))
+				if !msbBits {
+					mask = byte(1 << uint(col%8))
+				}
+				if bits[idx]&mask != 0 {
+					g.mask.Pix[row*g.mask.Stride+col] = 0xFF
+				}
+			}
+		}
+		glyphs = append(glyphs, g)
+	}
+	return
+}
+
+func parsePCFEncodings(data []byte, table pcfTable, add func(code rune, idx int)) (defaultChar rune, err error) {
+	r, _, err := newPCFReader(data, table)
+	if err != nil {
+		return
+	}
+	minByte2, maxByte2 := r.int16(), r.int16()
+	minByte1, maxByte1 := r.int16(), r.int16()
+	defaultChar = rune(r.int16())
+	for byte1 := minByte1; byte1 <= maxByte1 && r.err == nil; byte1++ {
+		for byte2 := minByte2; byte2 <= maxByte2 && r.err == nil; byte2++ {
+			idx := r.uint16()
+			if idx != 0xFFFF {
+				add(rune(byte1<<8|byte2), idx)
+			}
+		}
+	}
+	return defaultChar, r.err
+}
+
+// parsePCFProperties returns the FONT name and PIXEL_SIZE
+// properties of a font, if it has them
+func parsePCFProperties(data []byte, table pcfTable) (name string, pixelSize int) {
+	r, format, err := newPCFReader(data, table)
+	if err != nil {
+		return
+	}
+	count := r.count(r.int32(), 9)
+	type prop struct {
+		name     int
+		isString bool
+		value    int
+	}
+	props := make([]prop, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		props = append(props, prop{r.int32(), r.uint8() != 0, r.int32()})
+	}
+	// Properties are padded to a multiple of four
+	if count&3 != 0 {
+		r.bytes(4 - count&3)
+	}
+	size := r.int32()
+	strs := r.bytes(size)
+	if r.err != nil || format&pcfFormatMask != pcfDefaultFormat {
+		return
+	}
+	str := func(offset int) string {
+		if offset < 0 || offset >= len(strs) {
+			return ""
+		}
+		end := bytes.IndexByte(strs[offset:], 0)
+		if end < 0 {
+			return string(strs[offset:])
+		}
+		return string(strs[offset : offset+end])
+	}
+	for _, p := range props {
+		switch str(p.name) {
+		case "FONT":
+			if p.isString {
+				name = str(p.value)
+			}
+		case "PIXEL_SIZE":
+			if !p.isString {
+				pixelSize = p.value
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,348 @@
+package epd
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/kjk/flex"
+)
+
+// Chart node types. Their content is a series of numbers,
+// or a single number for a gauge.
+const (
+	// NodeTypeSparkline is a bare line of the series
+	NodeTypeSparkline = "sparkline"
+	// NodeTypeLine is a line chart with axes and labels
+	NodeTypeLine = "line"
+	// NodeTypeBar is a bar chart with axes and labels
+	NodeTypeBar = "bar"
+	// NodeTypeGauge is a half circle dial of the last value
+	NodeTypeGauge = "gauge"
+)
+
+// Natural size of a chart, before flex sizes it
+const (
+	defaultChartWidth  = 100
+	defaultChartHeight = 50
+)
+
+// dashLength is the length of the dashes of threshold lines
+const dashLength = 3
+
+func (n *Node) isChart() bool {
+	switch n.Type {
+	case NodeTypeSparkline, NodeTypeLine, NodeTypeBar, NodeTypeGauge:
+		return true
+	}
+	return false
+}
+
+// prepareChart converts the content of a chart node to its series
+func (n *Node) prepareChart() (err error) {
+	if !n.isChart() || n.Content == nil {
+		return
+	}
+	if n.series, err = chartSeries(n.Content); err != nil {
+		return &ContentError{ID: n.ID, Err: err}
+	}
+	// Numbers from templates are strings, which mustn't be drawn as text
+	n.Content = n.series
+	return
+}
+
+// measureChart is the size of a chart. It fills the space it's
+// given, or takes its natural size when there's no limit.
+func measureChart(width float64, widthMode flex.MeasureMode, height float64, heightMode flex.MeasureMode) (outWidth, outHeight float64) {
+	outWidth, outHeight = defaultChartWidth, defaultChartHeight
+	if widthMode != flex.MeasureModeUndefined {
+		outWidth = width
+	}
+	if heightMode == flex.MeasureModeExactly {
+		outHeight = height
+	}
+	return
+}
+
+// chartSeries converts content to a series of numbers. It can be
+// a single number, or a slice of numbers or strings of numbers.
+func chartSeries(content interface{}) (series []float64, err error) {
+	if f, err := toFloat(content); err == nil {
+		return []float64{f}, nil
+	}
+	v := reflect.ValueOf(content)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected numbers but got %T", content)
+	}
+	for idx := 0; idx < v.Len(); idx++ {
+		f, err := toFloat(v.Index(idx).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %s", idx, err.Error())
+		}
+		series = append(series, f)
+	}
+	return
+}
+
+// chartRange is the range of values the chart's axis covers,
+// the node's min and max if set, otherwise the series' range and
+// thresholds. Bar charts always include 0.
+func (n *Node) chartRange() (low, high float64) {
+	low, high = math.Inf(1), math.Inf(-1)
+	for _, v := range append(append([]float64(nil), n.series...), n.Thresholds...) {
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+	if n.Type == NodeTypeBar || n.Type == NodeTypeGauge {
+		low, high = math.Min(low, 0), math.Max(high, 0)
+	}
+	if n.Min != nil {
+		low = *n.Min
+	}
+	if n.Max != nil {
+		high = *n.Max
+	}
+	if high <= low {
+		high = low + 1
+	}
+	return
+}
+
+func formatChartValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// drawChart draws a chart node's series into bounds
+func (r flexRenderEngine) drawChart(node *Node, bounds image.Rectangle, dst *image.RGBA) {
+
+	if len(node.series) == 0 || bounds.Empty() {
+		return
+	}
+
+	fill := ColorFromString(node.Color)
+	if fill == nil {
+		fill = ColorBlack
+	}
+	stroke := float64(node.StrokeWidth)
+	if stroke <= 0 {
+		stroke = 1
+	}
+
+	low, high := node.chartRange()
+	style := node.TextStyle()
+	style.Overflow = TextOverflowVisible
+
+	if node.Type == NodeTypeGauge {
+		r.drawGauge(node, low, high, stroke, style, bounds, dst)
+		return
+	}
+
+	// Axes take a gutter for the labels of the range on the left,
+	// and a line for the labels of the series along the bottom
+	plot := bounds
+	withAxes := node.Type != NodeTypeSparkline
+	if withAxes {
+		face := r.textFace(style)
+		lineHeight := int(math.Ceil(Int26_6ToFloat64(face.Metrics().Height)))
+		gutter := int(math.Ceil(math.Max(face.measure(formatChartValue(low)), face.measure(formatChartValue(high))))) + 3
+		plot.Min.X += gutter
+		if len(node.Labels) > 0 {
+			plot.Max.Y -= lineHeight
+		}
+		// Half a line above the top so its label isn't cut off
+		plot.Min.Y += lineHeight / 2
+		// Labels are centred on the top and bottom of the plot
+		highLabel := image.Rect(bounds.Min.X, plot.Min.Y-lineHeight/2, plot.Min.X-3, plot.Min.Y+lineHeight/2+1)
+		lowLabel := image.Rect(bounds.Min.X, plot.Max.Y-lineHeight/2, plot.Min.X-3, plot.Max.Y+lineHeight/2+1)
+		labelStyle := style
+		labelStyle.Align = TextAlignRight
+		r.drawText(formatChartValue(high), labelStyle, highLabel, dst)
+		r.drawText(formatChartValue(low), labelStyle, lowLabel, dst)
+	}
+	if plot.Empty() {
+		return
+	}
+
+	// Points are inset so the line and markers aren't cut off
+	inset := stroke / 2
+	if node.Markers {
+		inset = stroke + 2
+	}
+	width, height := float64(plot.Dx()), float64(plot.Dy())
+	y := func(v float64) float64 {
+		if node.Type == NodeTypeBar {
+			return height - (v-low)/(high-low)*height
+		}
+		return height - inset - (v-low)/(high-low)*(height-2*inset)
+	}
+	count := len(node.series)
+	x := func(idx int) float64 {
+		if node.Type == NodeTypeBar {
+			return width * (float64(idx) + 0.5) / float64(count)
+		}
+		if count == 1 {
+			return width / 2
+		}
+		return inset + (width-2*inset)*float64(idx)/float64(count-1)
+	}
+
+	canvas := newShapeCanvas(plot)
+
+	if withAxes {
+		canvas.line(0, 0, 0, height, 1)
+		canvas.line(0, y(math.Max(low, math.Min(0, high))), width, y(math.Max(low, math.Min(0, high))), 1)
+	}
+
+	switch node.Type {
+	case NodeTypeBar:
+		slot := width / float64(count)
+		gap := math.Max(1, math.Floor(slot/5))
+		base := y(math.Max(low, math.Min(0, high)))
+		for idx, v := range node.series {
+			left := math.Round(float64(idx)*slot + gap/2)
+			right := math.Round(float64(idx+1)*slot - gap/2)
+			if right <= left {
+				right = left + 1
+			}
+			canvas.rect(left, math.Min(base, y(v)), right, math.Max(base, y(v)))
+		}
+	default:
+		var points [][2]float64
+		for idx, v := range node.series {
+			points = append(points, [2]float64{x(idx), y(v)})
+		}
+		canvas.polyline(points, stroke)
+	}
+
+	// Markers ring the lowest and highest values
+	var markers []int
+	if node.Markers {
+		lowest, highest := 0, 0
+		for idx, v := range node.series {
+			if v < node.series[lowest] {
+				lowest = idx
+			}
+			if v > node.series[highest] {
+				highest = idx
+			}
+		}
+		markers = []int{lowest, highest}
+		if node.Type != NodeTypeBar {
+			for _, idx := range markers {
+				canvas.circle(x(idx), y(node.series[idx]), stroke+2)
+			}
+		}
+	}
+
+	canvas.paint(dst, fill)
+
+	if node.Markers && node.Type != NodeTypeBar {
+		// Hollow out the markers so they stand out from the line
+		for _, idx := range markers {
+			canvas.circle(x(idx), y(node.series[idx]), 1)
+		}
+		canvas.paint(dst, ColorWhite)
+	}
+
+	for _, threshold := range node.Thresholds {
+		if threshold < low || threshold > high {
+			continue
+		}
+		canvas.dashed(0, y(threshold), width, y(threshold), 1, dashLength)
+	}
+	canvas.paint(dst, ColorRed)
+
+	if withAxes {
+		face := r.textFace(style)
+		lineHeight := int(math.Ceil(Int26_6ToFloat64(face.Metrics().Height)))
+		labelStyle := style
+		labelStyle.Align = TextAlignCenter
+		for idx, label := range node.Labels {
+			if idx >= count || label == "" {
+				continue
+			}
+			labelWidth := int(math.Ceil(face.measure(label))) + 1
+			left := plot.Min.X + int(x(idx)) - labelWidth/2
+			left = max(bounds.Min.X, min(left, bounds.Max.X-labelWidth))
+			r.drawText(label, labelStyle, image.Rect(left, plot.Max.Y, left+labelWidth, plot.Max.Y+lineHeight), dst)
+		}
+	}
+
+	if node.Markers {
+		// Label the markers with their values
+		labelStyle := style
+		face := r.textFace(labelStyle)
+		lineHeight := int(math.Ceil(Int26_6ToFloat64(face.Metrics().Height)))
+		for idx, markerIdx := range markers {
+			v := node.series[markerIdx]
+			label := formatChartValue(v)
+			labelWidth := int(math.Ceil(face.measure(label)))
+			left := plot.Min.X + int(x(markerIdx)) - labelWidth/2
+			left = max(plot.Min.X, min(left, plot.Max.X-labelWidth))
+			// The lowest value's label goes below it and the highest's
+			// above, unless there's no room
+			point := plot.Min.Y + int(y(v))
+			below, above := point+int(inset)+2, point-int(inset)-2-lineHeight
+			top := below
+			if (idx == 1 && above >= bounds.Min.Y) || below+lineHeight > plot.Max.Y {
+				top = above
+			}
+			top = max(bounds.Min.Y, min(top, plot.Max.Y-lineHeight))
+			r.drawText(label, labelStyle, image.Rect(left, top, left+labelWidth+1, top+lineHeight), dst)
+		}
+	}
+}
+
+// drawGauge draws a half circle dial filled up to the series' last
+// value, with the value written beneath it
+func (r flexRenderEngine) drawGauge(node *Node, low, high, stroke float64, style TextStyle, bounds image.Rectangle, dst *image.RGBA) {
+
+	face := r.textFace(style)
+	lineHeight := math.Ceil(Int26_6ToFloat64(face.Metrics().Height))
+
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	outer := math.Min(width/2, height-lineHeight)
+	if outer <= 2 {
+		return
+	}
+	thickness := math.Max(stroke, math.Round(outer/4))
+	inner := outer - thickness
+	cx, cy := width/2, outer
+
+	value := node.series[len(node.series)-1]
+	fraction := math.Max(0, math.Min(1, (value-low)/(high-low)))
+	angle := func(fraction float64) float64 {
+		return math.Pi + fraction*math.Pi
+	}
+
+	fill := ColorFromString(node.Color)
+	if fill == nil {
+		fill = ColorBlack
+	}
+
+	canvas := newShapeCanvas(bounds)
+	// The outline of the dial, and the filled part up to the value
+	canvas.arc(cx, cy, outer, outer-1, angle(0), angle(1))
+	canvas.arc(cx, cy, inner+1, inner, angle(0), angle(1))
+	canvas.rect(cx-outer, cy-1, cx-inner, cy)
+	canvas.rect(cx+inner, cy-1, cx+outer, cy)
+	if fraction > 0 {
+		canvas.arc(cx, cy, outer, inner, angle(0), angle(fraction))
+	}
+	canvas.paint(dst, fill)
+
+	for _, threshold := range node.Thresholds {
+		if threshold < low || threshold > high {
+			continue
+		}
+		a := angle((threshold - low) / (high - low))
+		canvas.line(cx+(inner-2)*math.Cos(a), cy+(inner-2)*math.Sin(a), cx+(outer+2)*math.Cos(a), cy+(outer+2)*math.Sin(a), math.Max(2, stroke))
+	}
+	canvas.paint(dst, ColorRed)
+
+	labelStyle := style
+	labelStyle.Align = TextAlignCenter
+	top := bounds.Min.Y + int(cy) + 1
+	r.drawText(formatChartValue(value), labelStyle, image.Rect(bounds.Min.X, top, bounds.Max.X, top+int(lineHeight)), dst)
+}
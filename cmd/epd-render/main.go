@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"image"
 	"image/png"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,47 +16,183 @@ import (
 )
 
 var (
-	LOGLEVEL = "INFO"
+	TEMPLATES = ""
+	CONTENT   = ""
+	DEBUG     = false
+	LAYOUT    = ""
+	FORMAT    = "png"
+	LOGLEVEL  = "INFO"
 )
 
 func main() {
 
 	fs := flag.NewFlagSetWithEnvPrefix(os.Args[0], "EPD", 0)
+	fs.StringVar(&TEMPLATES, "templates", TEMPLATES, "Directory of json and yaml templates to render or validate by name")
+	fs.StringVar(&CONTENT, "content", CONTENT, "Json file of content to render. Omit for sample content")
+	fs.BoolVar(&DEBUG, "debug", DEBUG, "Draw the box, padding and id of each node over the rendered image")
+	fs.StringVar(&LAYOUT, "layout", LAYOUT, "Json file to write the laid out boxes of the nodes to")
+	fs.StringVar(&FORMAT, "format", FORMAT, "Format to render to, png, svg or pdf")
 	fs.StringVar(&LOGLEVEL, "loglevel", LOGLEVEL, "loglevel for app.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Commands:")
+		fmt.Fprintln(os.Stderr, "  render [template]        Render a template to image.png, .svg or .pdf (default)")
+		fmt.Fprintln(os.Stderr, "  validate [template ...]  Check templates, by file or name, for mistakes")
+		fmt.Fprintln(os.Stderr, "  schema                   Print the JSON Schema of templates")
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
 	fs.Parse(os.Args[1:])
 
 	configureLogging(LOGLEVEL)
 
+	switch fs.Arg(0) {
+	case "", "render":
+		render(fs.Arg(1))
+	case "validate":
+		if !validate(fs.Args()[1:]) {
+			os.Exit(1)
+		}
+	case "schema":
+		schema, err := epd.TemplateSchema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(schema))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// validate prints the problems of each template, which is a
+// file or the name of a template in the templates directory.
+// With no templates, it checks all of them. It returns false
+// if any template has problems.
+func validate(names []string) (ok bool) {
+
+	templates := templateLoader()
+	if len(names) == 0 {
+		names = templates.Names()
+	}
+
+	ok = true
+	for _, name := range names {
+		tpl, err := loadTemplate(templates, name)
+		if err != nil {
+			fmt.Printf("%s: %s\n", name, err.Error())
+			ok = false
+			continue
+		}
+		// auto picks landscape or portrait at render time
+		if tpl == epd.TplDefaultAuto {
+			continue
+		}
+		for _, problem := range epd.ValidateTemplate(tpl) {
+			fmt.Printf("%s: %s\n", name, problem)
+			ok = false
+		}
+	}
+	return
+}
+
+func templateLoader() *epd.TemplateLoader {
+	if TEMPLATES != "" {
+		return epd.NewTemplateDir(TEMPLATES)
+	}
+	return epd.NewTemplateLoader(nil)
+}
+
+// loadTemplate loads a template from a file, or by name
+// from the templates directory
+func loadTemplate(templates *epd.TemplateLoader, name string) (tpl epd.RenderTemplate, err error) {
+	if info, errr := os.Stat(name); errr == nil && !info.IsDir() {
+		return epd.NewTemplateDir(filepath.Dir(name)).Load(filepath.Base(name))
+	}
+	return templates.Load(name)
+}
+
+func render(name string) {
+
+	if FORMAT != "png" && FORMAT != "svg" && FORMAT != "pdf" {
+		log.Fatalf("Unknown format %s, expected png, svg or pdf", FORMAT)
+	}
+
 	engine, err := epd.NewFlexRenderEngine(9, 72)
 	if err != nil {
 		panic(err)
 	}
+	engine = engine.WithDebug(DEBUG)
 
-	showImg, _ := loadImageFromUrl("https://loremflickr.com/400/300")
+	tpl := epd.TplDefaultAuto
+	if name != "" {
+		if tpl, err = loadTemplate(templateLoader(), name); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	content := epd.RenderContent{
-		"title": "Hello World!",
-		"body": `Here's a lovely picture that's probably of a cat.
+	var content epd.RenderContent
+	if CONTENT != "" {
+		data, err := os.ReadFile(CONTENT)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = json.Unmarshal(data, &content); err != nil {
+			log.Fatalf("Content %s: %s", CONTENT, err.Error())
+		}
+	} else {
+		showImg, _ := loadImageFromUrl("https://loremflickr.com/400/300")
+
+		content = epd.RenderContent{
+			"title": "Hello World!",
+			"body": `Here's a lovely picture that's probably of a cat.
 It's really hard to tell with this flickr account because it always sends you something random.`,
-		"footer": "Hope you like it",
-		"img":    showImg,
+			"footer": "Hope you like it",
+			"img":    showImg,
+		}
 	}
 
-	img, err := engine.Render(content, 400, 300, epd.TplDefaultAuto)
+	if LAYOUT != "" {
+		writeLayout(engine, content, tpl, LAYOUT)
+	}
+
+	f, err := os.Create("image." + FORMAT)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	switch FORMAT {
+	case "png":
+		img, err := engine.Render(content, 400, 300, tpl)
+		if err != nil {
+			log.Fatal("Render error", err)
+		}
+		err = png.Encode(f, img)
+	case "svg":
+		err = engine.RenderSVG(f, content, 400, 300, tpl)
+	case "pdf":
+		err = engine.RenderPDF(f, content, 400, 300, tpl)
+	}
 	if err != nil {
 		log.Fatal("Render error", err)
 	}
 
-	f, err := os.Create("image.png")
+}
+
+// writeLayout writes the layout of content in a template to file as json
+func writeLayout(layouter epd.Layouter, content epd.RenderContent, tpl epd.RenderTemplate, file string) {
+	box, err := layouter.Layout(content, 400, 300, tpl)
+	if err != nil {
+		log.Fatal("Layout error", err)
+	}
+	data, err := json.MarshalIndent(box, "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	if err := png.Encode(f, img); err != nil {
-		f.Close()
+	if err = os.WriteFile(file, data, 0644); err != nil {
 		log.Fatal(err)
 	}
-
 }
 
 func loadImageFromUrl(url string) (img image.Image, err error) {
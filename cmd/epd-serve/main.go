@@ -2,9 +2,8 @@ package main
 
 import (
 	"fmt"
-	"image"
-	"net/http"
 	"os"
+	"time"
 
 	"github.com/namsral/flag"
 	log "github.com/sirupsen/logrus"
@@ -20,6 +19,10 @@ var (
 	BUSY        = ""
 	SPI_ADDRESS = ""
 	ORIENTATION = ""
+	STATS_FILE  = ""
+	FONTS_DIR   = ""
+	TEMPLATES   = ""
+	TEMPLATE    = "auto"
 	LOGLEVEL    = "WARN"
 )
 
@@ -33,30 +36,82 @@ func main() {
 	fs.StringVar(&BUSY, "busy", BUSY, "BUSY GPIO pin")
 	fs.StringVar(&SPI_ADDRESS, "spi", SPI_ADDRESS, "Spi bus address. Omit or leave blank for default (recommended)")
 	fs.StringVar(&ORIENTATION, "orientation", ORIENTATION, "Orientation of attached display. 'portrait' or 'landscape'")
+	fs.StringVar(&STATS_FILE, "stats", STATS_FILE, "Json file to persist panel refresh stats to. Omit to keep stats in memory only")
+	fs.StringVar(&FONTS_DIR, "fonts", FONTS_DIR, "Directory of extra ttf, bdf and pcf fonts for templates to use. Named family-weight-style.ext")
+	fs.StringVar(&TEMPLATES, "templates", TEMPLATES, "Directory of json and yaml templates. Changes are picked up while running")
+	fs.StringVar(&TEMPLATE, "template", TEMPLATE, "Name of the template to show content with when a post doesn't name one. auto, landscape, portrait or a file in the templates directory")
 	fs.StringVar(&LOGLEVEL, "loglevel", LOGLEVEL, "Log level for app")
 	fs.Parse(os.Args[1:])
 
-	display, err := epd.Epd42(epd.OrientationFromString(ORIENTATION), SPI_ADDRESS, RESET, DC, BUSY)
+	configureLogging(LOGLEVEL)
+
+	templates := epd.NewTemplateLoader(nil)
+	if TEMPLATES != "" {
+		templates = epd.NewTemplateDir(TEMPLATES)
+		stop := templates.Watch(2*time.Second, func(file string) {
+			log.Infof("Template %s changed, reloading", file)
+		})
+		defer stop()
+	}
+	// Fail early on a template that can't load
+	if _, err := templates.Load(TEMPLATE); err != nil {
+		panic(err)
+	}
+
+	var renderOpts []epd.RenderOpts
+	if FONTS_DIR != "" {
+		engine, err := epd.NewFlexRenderEngine(11, 72)
+		if err != nil {
+			panic(err)
+		}
+		if err = engine.Fonts().LoadDir(FONTS_DIR); err != nil {
+			panic(err)
+		}
+		renderOpts = append(renderOpts, epd.RenderOpts{
+			Renderer: engine,
+			Template: epd.TplDefaultAuto,
+		})
+	}
+
+	display, err := epd.Epd42(epd.OrientationFromString(ORIENTATION), SPI_ADDRESS, RESET, DC, BUSY, renderOpts...)
 	if err != nil {
 		panic(err)
 	}
 
+	reporter, hasStats := display.(epd.StatsReporter)
+	if hasStats && STATS_FILE != "" {
+		if err = reporter.SetStatsFile(STATS_FILE); err != nil {
+			panic(err)
+		}
+	}
+
 	server := serve.New()
 
-	server.HandleContent(func(content serve.DisplayContent) {
+	if hasStats {
+		server.HandleStats(func() (interface{}, error) {
+			return reporter.Stats(), nil
+		})
+	}
+
+	// Concurrent posts are coalesced by the queue, so only
+	// the newest content waiting to be shown makes it to the panel
+	queue := epd.NewUpdateQueue(display)
+	defer queue.Close()
+
+	server.HandleContent(func(content serve.DisplayContent) error {
 
 		log.Debugf("Got content from server %v", content)
 
-		if content.Image == nil && content.ImageUrl != "" {
-			content.Image, err = loadImageFromUrl(content.ImageUrl)
+		name := content.Template
+		if name == "" {
+			name = TEMPLATE
+		}
+		tpl, err := templates.Load(name)
+		if err != nil {
+			return err
 		}
 
-		display.Show(epd.RenderContent{
-			"title":  content.Title,
-			"body":   content.Body,
-			"img":    content.Image,
-			"footer": content.Footer,
-		})
+		return queue.ShowWithTemplate(content.Content, tpl).Wait()
 	})
 
 	server.Echo.Server.Addr = fmt.Sprintf("%s:%d", ADDR, PORT)
@@ -68,17 +123,6 @@ func main() {
 
 }
 
-func loadImageFromUrl(url string) (img image.Image, err error) {
-	response, errr := http.Get(url)
-	if err != nil {
-		err = errr
-		return
-	}
-	defer response.Body.Close()
-	img, _, err = image.Decode(response.Body)
-	return
-}
-
 func configureLogging(level string) {
 	switch level {
 	case "INFO":
@@ -0,0 +1,231 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	epd "github.com/woosteln/goepd"
+)
+
+const (
+	// templateParam names the template to show content with
+	templateParam = "template"
+	// contentParam is a form field of json content, so typed
+	// values can be posted along with files
+	contentParam = "content"
+	// legacyImageID is the node the image and imageurl fields
+	// were shown in before content was keyed by node id
+	legacyImageID = "img"
+)
+
+// imageClient fetches images posted by url
+var imageClient = &http.Client{Timeout: 30 * time.Second}
+
+// readContent reads the posted content, as json or as a form.
+//
+// Json is an object of content keyed by node id. Strings,
+// numbers and bools are shown as text, and lists and objects
+// are given to repeaters, tables and charts as they are.
+// Images are objects with a type of image, and a url to fetch
+// them from or base64 data
+//
+//	{"photo": {"type": "image", "url": "https://example.com/cat.jpg"}}
+//	{"logo": {"type": "image", "data": "iVBORw0KGgo..."}}
+//
+// Form fields are text keyed by node id, files are images keyed
+// by node id, and a content field can hold json content as above.
+// The template is a template field or query parameter.
+func readContent(c echo.Context) (content DisplayContent, err error) {
+
+	content.Content = epd.RenderContent{}
+	content.Template = c.QueryParam(templateParam)
+
+	req := c.Request()
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		var values map[string]interface{}
+		if err = json.NewDecoder(req.Body).Decode(&values); err != nil {
+			err = fmt.Errorf("Invalid json content: %s", err.Error())
+			return
+		}
+		err = content.addJSON(values)
+	} else {
+		err = content.addForm(c)
+	}
+	if err != nil {
+		return
+	}
+
+	// imageurl is kept as a plain string for older clients, which
+	// expect the rest of their content shown if it can't be fetched
+	if url, ok := content.Content["imageurl"].(string); ok && url != "" {
+		if img, errr := fetchImage(url); errr != nil {
+			log.Warnf("Image from imageurl not shown: %s", errr.Error())
+			delete(content.Content, "imageurl")
+		} else {
+			content.Content["imageurl"] = img
+		}
+	}
+	if _, ok := content.Content[legacyImageID]; !ok {
+		for _, id := range []string{"image", "imageurl"} {
+			if img, ok := content.Content[id].(image.Image); ok {
+				content.Content[legacyImageID] = img
+				break
+			}
+		}
+	}
+	return
+}
+
+// addJSON adds json content keyed by node id
+func (content *DisplayContent) addJSON(values map[string]interface{}) (err error) {
+	for id, value := range values {
+		if id == templateParam {
+			if name, ok := value.(string); ok {
+				content.Template = name
+				continue
+			}
+			return fmt.Errorf("Template should be a name, not %T", value)
+		}
+		if content.Content[id], err = decodeValue(value); err != nil {
+			return &epd.ContentError{ID: id, Err: err}
+		}
+	}
+	return
+}
+
+// addForm adds form fields as text, files as images and the
+// content field as json
+func (content *DisplayContent) addForm(c echo.Context) (err error) {
+
+	params, err := c.FormParams()
+	if err != nil {
+		return
+	}
+	for id, values := range params {
+		if len(values) == 0 {
+			continue
+		}
+		switch id {
+		case templateParam:
+			content.Template = values[0]
+		case contentParam:
+			var parsed map[string]interface{}
+			if err = json.Unmarshal([]byte(values[0]), &parsed); err != nil {
+				return &epd.ContentError{ID: id, Err: err}
+			}
+			if err = content.addJSON(parsed); err != nil {
+				return
+			}
+		default:
+			content.Content[id] = values[0]
+		}
+	}
+
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return
+	}
+	for id, files := range form.File {
+		if len(files) == 0 {
+			continue
+		}
+		if content.Content[id], err = decodeFile(files[0]); err != nil {
+			return &epd.ContentError{ID: id, Err: err}
+		}
+	}
+	return
+}
+
+// decodeValue turns a json value into content. Typed values
+// are decoded, in lists and objects too, so repeaters can show
+// images.
+func decodeValue(value interface{}) (content interface{}, err error) {
+	switch x := value.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(x))
+		for idx, item := range x {
+			if items[idx], err = decodeValue(item); err != nil {
+				return
+			}
+		}
+		return items, nil
+	case map[string]interface{}:
+		switch x["type"] {
+		case "image":
+			return decodeImage(x)
+		case "text":
+			text, ok := x["text"].(string)
+			if !ok {
+				return nil, errors.New("Text should have a text string")
+			}
+			return text, nil
+		}
+		fields := make(map[string]interface{}, len(x))
+		for key, field := range x {
+			if fields[key], err = decodeValue(field); err != nil {
+				return
+			}
+		}
+		return fields, nil
+	}
+	return value, nil
+}
+
+// decodeImage decodes an image value from its url or data
+func decodeImage(value map[string]interface{}) (img image.Image, err error) {
+	if url, ok := value["url"].(string); ok {
+		return fetchImage(url)
+	}
+	data, ok := value["data"].(string)
+	if !ok {
+		return nil, errors.New("Image should have a url or base64 data")
+	}
+	// Data urls are accepted as well as plain base64
+	if strings.HasPrefix(data, "data:") {
+		if comma := strings.Index(data, ","); comma >= 0 {
+			data = data[comma+1:]
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("Image data isn't base64: %s", err.Error())
+	}
+	img, _, err = image.Decode(bytes.NewReader(decoded))
+	return
+}
+
+func decodeFile(header *multipart.FileHeader) (img image.Image, err error) {
+	file, err := header.Open()
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	img, _, err = image.Decode(file)
+	return
+}
+
+func fetchImage(url string) (img image.Image, err error) {
+	response, err := imageClient.Get(url)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s returned %s", url, response.Status)
+	}
+	img, _, err = image.Decode(response.Body)
+	return
+}
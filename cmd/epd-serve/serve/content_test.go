@@ -0,0 +1,240 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	epd "github.com/woosteln/goepd"
+)
+
+// testPNG is a 3x2 png
+func testPNG(t *testing.T) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func assertImage(t *testing.T, value interface{}, what string) {
+	t.Helper()
+	img, ok := value.(image.Image)
+	if !ok {
+		t.Fatalf("Expected %s to be an image, got %T", what, value)
+	}
+	if img.Bounds().Dx() != 3 || img.Bounds().Dy() != 2 {
+		t.Errorf("Expected %s to be 3x2, got %v", what, img.Bounds())
+	}
+}
+
+func readRequest(t *testing.T, req *http.Request) (DisplayContent, error) {
+	t.Helper()
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	return readContent(c)
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+		err   bool
+	}{
+		{name: "string", value: "Light rain", want: "Light rain"},
+		{name: "number", value: 12.5, want: 12.5},
+		{name: "bool", value: true, want: true},
+		{name: "text", value: map[string]interface{}{"type": "text", "text": "Hi"}, want: "Hi"},
+		{name: "text without text", value: map[string]interface{}{"type": "text"}, err: true},
+		{name: "image without data", value: map[string]interface{}{"type": "image"}, err: true},
+		{name: "image not base64", value: map[string]interface{}{"type": "image", "data": "not base64!"}, err: true},
+		{
+			name:  "object",
+			value: map[string]interface{}{"day": "Mon", "high": 14.0},
+			want:  map[string]interface{}{"day": "Mon", "high": 14.0},
+		},
+		{
+			name:  "list",
+			value: []interface{}{"a", map[string]interface{}{"type": "text", "text": "b"}},
+			want:  []interface{}{"a", "b"},
+		},
+		{
+			name:  "error in a list",
+			value: []interface{}{"a", map[string]interface{}{"type": "image"}},
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeValue(test.value)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestReadJSONContent(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString(testPNG(t))
+	body := `{
+		"template": "weather",
+		"summary": "Light rain",
+		"logo": {"type": "image", "data": "` + data + `"},
+		"icons": [{"type": "image", "data": "data:image/png;base64,` + data + `"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	content, err := readRequest(t, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Template != "weather" {
+		t.Errorf("Expected the weather template, got %q", content.Template)
+	}
+	if content.Content["summary"] != "Light rain" {
+		t.Errorf("Expected the summary as text, got %v", content.Content["summary"])
+	}
+	assertImage(t, content.Content["logo"], "logo")
+	icons, _ := content.Content["icons"].([]interface{})
+	if len(icons) != 1 {
+		t.Fatalf("Expected 1 icon, got %v", content.Content["icons"])
+	}
+	assertImage(t, icons[0], "the data url icon")
+	if _, ok := content.Content[templateParam]; ok {
+		t.Error("Expected the template to not be content")
+	}
+}
+
+func TestReadJSONContentErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"template": 3}`,
+		`{"title": `,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if _, err := readRequest(t, req); err == nil {
+			t.Errorf("Expected an error reading %s", body)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader(`{"logo": {"type": "image"}}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	var contentErr *epd.ContentError
+	if _, err := readRequest(t, req); !errors.As(err, &contentErr) || contentErr.ID != "logo" {
+		t.Errorf("Expected a content error for logo, got %v", err)
+	}
+}
+
+func TestReadMultipartContent(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("title", "Hello")
+	form.WriteField("content", `{"temperature": 12.5, "template": "weather"}`)
+	file, err := form.CreateFormFile("radar", "radar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(testPNG(t))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/display/content", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	content, err := readRequest(t, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Template != "weather" {
+		t.Errorf("Expected the template from the content field, got %q", content.Template)
+	}
+	if content.Content["title"] != "Hello" {
+		t.Errorf("Expected the title as text, got %v", content.Content["title"])
+	}
+	if content.Content["temperature"] != 12.5 {
+		t.Errorf("Expected the temperature as a number, got %v", content.Content["temperature"])
+	}
+	assertImage(t, content.Content["radar"], "radar")
+	if _, ok := content.Content[contentParam]; ok {
+		t.Error("Expected the content field to not be content")
+	}
+}
+
+func TestReadFormContent(t *testing.T) {
+	values := url.Values{"title": {"Hello"}, "template": {"notes"}}
+	req := httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader(values.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	content, err := readRequest(t, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Template != "notes" || content.Content["title"] != "Hello" {
+		t.Errorf("Unexpected content %+v", content)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/display/content?template=clock", strings.NewReader("title=Hello"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if content, err = readRequest(t, req); err != nil || content.Template != "clock" {
+		t.Errorf("Expected the template from the query, got %q, %v", content.Template, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader("content={"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if _, err = readRequest(t, req); err == nil {
+		t.Error("Expected an error for invalid json in the content field")
+	}
+}
+
+func TestReadImageURL(t *testing.T) {
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cat.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testPNG(t))
+	}))
+	defer images.Close()
+
+	post := func(imageURL string) DisplayContent {
+		values := url.Values{"title": {"Hello"}, "imageurl": {imageURL}}
+		req := httptest.NewRequest(http.MethodPost, "/display/content", strings.NewReader(values.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		content, err := readRequest(t, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+
+	content := post(images.URL + "/cat.png")
+	assertImage(t, content.Content["imageurl"], "imageurl")
+	assertImage(t, content.Content[legacyImageID], legacyImageID)
+
+	// An image that can't be fetched is left out
+	content = post(images.URL + "/missing.png")
+	if _, ok := content.Content["imageurl"]; ok {
+		t.Errorf("Expected the missing image to be left out, got %v", content.Content["imageurl"])
+	}
+	if _, ok := content.Content[legacyImageID]; ok {
+		t.Error("Expected no image")
+	}
+	if content.Content["title"] != "Hello" {
+		t.Errorf("Expected the rest of the content, got %v", content.Content)
+	}
+}
@@ -1,26 +1,27 @@
 package serve
 
 import (
-	"image"
+	"errors"
 
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 
 	"github.com/labstack/echo"
-	log "github.com/sirupsen/logrus"
+	epd "github.com/woosteln/goepd"
 )
 
+// DisplayContent is posted content keyed by the ids of the
+// nodes it fills, and the name of the template to show it
+// with, which is empty for the default template.
 type DisplayContent struct {
-	Title    string      `json:"title"`
-	Body     string      `json:"body"`
-	Image    image.Image `json:"image"`
-	ImageUrl string      `json:"imageurl"`
-	Footer   string      `json:"footer"`
+	Template string
+	Content  epd.RenderContent
 }
 
 type serverData struct {
 	ContentHandler
+	StatsHandler
 }
 
 type EpdServer struct {
@@ -28,12 +29,21 @@ type EpdServer struct {
 	Echo *echo.Echo
 }
 
-type ContentHandler func(content DisplayContent)
+// ContentHandler updates the display with content, returning
+// the outcome of the update
+type ContentHandler func(content DisplayContent) error
+
+// StatsHandler returns the stats of the attached panels
+type StatsHandler func() (stats interface{}, err error)
 
 func (e EpdServer) HandleContent(handler ContentHandler) {
 	e.ContentHandler = handler
 }
 
+func (e EpdServer) HandleStats(handler StatsHandler) {
+	e.StatsHandler = handler
+}
+
 func New() EpdServer {
 
 	e := echo.New()
@@ -43,36 +53,41 @@ func New() EpdServer {
 	// Update the display content
 	e.POST("/display/content", func(c echo.Context) (err error) {
 
-		content := DisplayContent{}
-		if err = c.Bind(&content); err != nil {
-			return
-		}
-
-		formImage, err := c.FormFile("image")
-
+		content, err := readContent(c)
 		if err != nil {
-			log.Warn(err.Error())
-		} else {
-			file, err := formImage.Open()
-			if err != nil {
-				log.Warn(err.Error())
-			}
-			img, format, err := image.Decode(file)
-			if err != nil {
-				log.Warn(err.Error())
-			} else {
-				log.Info(format)
-				content.Image = img
-			}
+			return c.String(400, err.Error())
 		}
 
 		if server.ContentHandler != nil {
-			server.ContentHandler(content)
+			err = server.ContentHandler(content)
+			var templateErr *epd.TemplateError
+			var contentErr *epd.ContentError
+			switch {
+			case err == nil:
+			case errors.Is(err, epd.ErrUpdateSuperseded):
+				return c.String(409, err.Error())
+			case errors.As(err, &templateErr), errors.As(err, &contentErr), errors.Is(err, epd.ErrTemplateNotFound):
+				return c.String(400, err.Error())
+			default:
+				return c.String(500, err.Error())
+			}
 		}
 
 		return c.String(201, "OK")
 	})
 
+	// Return refresh statistics for the attached panels
+	e.GET("/stats", func(c echo.Context) (err error) {
+		if server.StatsHandler == nil {
+			return c.String(404, "Stats not available")
+		}
+		stats, err := server.StatsHandler()
+		if err != nil {
+			return
+		}
+		return c.JSON(200, stats)
+	})
+
 	// Return the current display image
 	e.GET("/", func(c echo.Context) (err error) {
 		return c.String(200, "OK")
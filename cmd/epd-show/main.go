@@ -25,6 +25,8 @@ var (
 	BUSY        = ""
 	SPI_ADDRESS = ""
 	IMAGE       = ""
+	TEMPLATES   = ""
+	TEMPLATE    = "auto"
 	LOGLEVEL    = "WARN"
 )
 
@@ -35,12 +37,23 @@ func main() {
 	fs.StringVar(&RESET, "rst", RESET, "Name of RESET GPIO pin")
 	fs.StringVar(&BUSY, "busy", BUSY, "Name of BUSY GPIO pin")
 	fs.StringVar(&SPI_ADDRESS, "spi", SPI_ADDRESS, "SPI address. Use blank for default")
+	fs.StringVar(&TEMPLATES, "templates", TEMPLATES, "Directory of json and yaml templates")
+	fs.StringVar(&TEMPLATE, "template", TEMPLATE, "Name of the template to show the image with. auto, landscape, portrait or a file in the templates directory")
 	fs.StringVar(&LOGLEVEL, "loglevel", LOGLEVEL, "logging level to use")
 	fs.Parse(os.Args[1:])
 	IMAGE = os.Args[len(os.Args)-1]
 
 	configureLogging(LOGLEVEL)
 
+	templates := epd.NewTemplateLoader(nil)
+	if TEMPLATES != "" {
+		templates = epd.NewTemplateDir(TEMPLATES)
+	}
+	tpl, err := templates.Load(TEMPLATE)
+	if err != nil {
+		panic(err)
+	}
+
 	display, err := epd.Epd42(epd.Landscape, SPI_ADDRESS, RESET, DC, BUSY)
 	if err != nil {
 		panic(err)
@@ -72,7 +85,7 @@ func main() {
 		"img": img,
 	}
 
-	err = display.Show(content)
+	err = display.ShowWithTemplate(content, tpl)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,157 @@
+package epd
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+const (
+	// NodeTypeQR is a node that draws its content as a QR code
+	NodeTypeQR = "qr"
+	// NodeTypeBarcode is a node that draws its content as
+	// a barcode in its symbology
+	NodeTypeBarcode = "barcode"
+)
+
+// Quiet zones in modules when a node doesn't set one,
+// as the specifications ask for
+const (
+	defaultQRQuietZone      = 4
+	defaultBarcodeQuietZone = 10
+)
+
+// defaultModuleSize is the size in pixels of a module
+// when measuring a code without a moduleSize
+const defaultModuleSize = 2
+
+// codeModules is an encoded QR code or barcode
+type codeModules interface {
+	// moduleCount is the number of modules across and down,
+	// without the quiet zone. Barcodes are 1 module high.
+	moduleCount() (cols, rows int)
+	black(x, y int) bool
+}
+
+func (q *QRCode) moduleCount() (cols, rows int) {
+	return q.Size, q.Size
+}
+
+func (q *QRCode) black(x, y int) bool {
+	return q.Black(x, y)
+}
+
+func (b Barcode) moduleCount() (cols, rows int) {
+	return len(b.Modules), 1
+}
+
+func (b Barcode) black(x, y int) bool {
+	return b.Modules[x]
+}
+
+// prepareCode encodes the content of qr and barcode nodes,
+// so encoding errors are found before layout
+func (n *Node) prepareCode() (err error) {
+	text, isString := n.Content.(string)
+	if !isString || text == "" {
+		return
+	}
+	switch n.Type {
+	case NodeTypeQR:
+		n.code, err = EncodeQR(text, QRLevelFromString(n.ErrorCorrection))
+	case NodeTypeBarcode:
+		var code Barcode
+		code, err = EncodeBarcode(text, n.Symbology)
+		n.code = code
+	}
+	if err != nil {
+		return &ContentError{ID: n.ID, Err: err}
+	}
+	return
+}
+
+// codeSize is the number of modules across and down a node's
+// code, including its quiet zone
+func (n *Node) codeSize() (cols, rows int) {
+	cols, rows = n.code.moduleCount()
+	quiet := defaultQRQuietZone
+	if n.Type == NodeTypeBarcode {
+		quiet = defaultBarcodeQuietZone
+	}
+	if n.QuietZone != nil {
+		quiet = max(*n.QuietZone, 0)
+	}
+	cols += 2 * quiet
+	if n.Type == NodeTypeQR {
+		rows += 2 * quiet
+	}
+	return
+}
+
+// measureCode is the natural size of a node's code, at its module
+// size. Barcodes are a quarter as high as they are wide.
+func (n *Node) measureCode() (width, height float64) {
+	moduleSize := float64(n.ModuleSize)
+	if moduleSize <= 0 {
+		moduleSize = defaultModuleSize
+	}
+	cols, rows := n.codeSize()
+	width = float64(cols) * moduleSize
+	height = float64(rows) * moduleSize
+	if n.Type == NodeTypeBarcode {
+		height = math.Round(width / 4)
+	}
+	return
+}
+
+// drawCode draws a node's code centred in bounds. Modules are
+// whole pixels so the code stays sharp enough to scan. Without
+// a moduleSize they are as large as will fit.
+func (r flexRenderEngine) drawCode(node *Node, bounds image.Rectangle, dst *image.RGBA) {
+
+	cols, rows := node.codeSize()
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	moduleSize := int(node.ModuleSize)
+	if moduleSize <= 0 {
+		moduleSize = bounds.Dx() / cols
+		if node.Type == NodeTypeQR {
+			moduleSize = min(moduleSize, bounds.Dy()/rows)
+		}
+	}
+	if moduleSize < 1 {
+		moduleSize = 1
+	}
+
+	width, height := cols*moduleSize, rows*moduleSize
+	if node.Type == NodeTypeBarcode {
+		height = bounds.Dy()
+	}
+	origin := image.Point{
+		X: bounds.Min.X + (bounds.Dx()-width)/2,
+		Y: bounds.Min.Y + (bounds.Dy()-height)/2,
+	}
+
+	// Quiet zones must be light to scan, whatever is behind
+	draw.Draw(dst, image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}, &image.Uniform{ColorWhite}, image.ZP, draw.Src)
+
+	fill := ColorFromString(node.Color)
+	if fill == nil {
+		fill = ColorBlack
+	}
+	codeCols, codeRows := node.code.moduleCount()
+	quietX, quietY := (cols-codeCols)/2, (rows-codeRows)/2
+	for y := 0; y < codeRows; y++ {
+		for x := 0; x < codeCols; x++ {
+			if !node.code.black(x, y) {
+				continue
+			}
+			module := image.Rect(0, 0, moduleSize, moduleSize).Add(origin).Add(image.Pt((x+quietX)*moduleSize, (y+quietY)*moduleSize))
+			if node.Type == NodeTypeBarcode {
+				module.Max.Y = origin.Y + height
+			}
+			draw.Draw(dst, module, &image.Uniform{fill}, image.ZP, draw.Src)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package epd
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestNegativeQuietZone checks a quiet zone that cancels out the
+// width of the code doesn't stop it being drawn
+func TestNegativeQuietZone(t *testing.T) {
+	for _, quietZone := range []int{-23, -100} {
+		tpl := RenderTemplate(fmt.Sprintf(`{"children": [{"id": "code", "type": "barcode", "quietZone": %d, "flexGrow": 1}]}`, quietZone))
+		if _, err := goldenEngine().Render(RenderContent{"code": "A"}, 400, 300, tpl); err != nil {
+			t.Errorf("Rendering a quiet zone of %d: %s", quietZone, err.Error())
+		}
+	}
+}
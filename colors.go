@@ -0,0 +1,148 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ColorBlack = color.RGBA{0, 0, 0, 255}
+	ColorWhite = color.RGBA{255, 255, 255, 255}
+	ColorRed   = color.RGBA{255, 0, 0, 255}
+)
+
+// PanelPalette is the colours a black, white and red panel
+// can show. Template colours are snapped to the nearest.
+var PanelPalette = color.Palette{ColorWhite, ColorBlack, ColorRed}
+
+var colorNames = map[string]color.RGBA{
+	"black": ColorBlack,
+	"white": ColorWhite,
+	"red":   ColorRed,
+}
+
+// ColorFromString parses a colour name (black, white or red) or
+// a hex colour such as #f00 or #ff0000, snapped to the nearest
+// colour of the PanelPalette. It returns nil for "transparent"
+// or anything it can't parse.
+func ColorFromString(c string) color.Color {
+	c = strings.ToLower(strings.TrimSpace(c))
+	if named, ok := colorNames[c]; ok {
+		return named
+	}
+	if !strings.HasPrefix(c, "#") {
+		return nil
+	}
+	hex := c[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil
+	}
+	return PanelPalette.Convert(color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255})
+}
+
+// roundedRect is a rectangle with rounded corners, used to
+// fill backgrounds and borders. Pixels are either in or out,
+// anti-aliased edges only turn ragged on the panel.
+type roundedRect struct {
+	rect   image.Rectangle
+	radius float64
+}
+
+// contains reports whether the centre of pixel x, y is in the rectangle
+func (r roundedRect) contains(x, y int) bool {
+	if !(image.Point{x, y}).In(r.rect) {
+		return false
+	}
+	radius := math.Min(r.radius, float64(min(r.rect.Dx(), r.rect.Dy()))/2)
+	if radius <= 0 {
+		return true
+	}
+	px, py := float64(x)+0.5, float64(y)+0.5
+	// Distance into each corner's square, measured from the
+	// centre of the corner's circle
+	cx := math.Max(float64(r.rect.Min.X)+radius-px, px-(float64(r.rect.Max.X)-radius))
+	cy := math.Max(float64(r.rect.Min.Y)+radius-py, py-(float64(r.rect.Max.Y)-radius))
+	if cx <= 0 || cy <= 0 {
+		return true
+	}
+	return cx*cx+cy*cy <= radius*radius
+}
+
+// inset returns the rectangle shrunk by width on each edge,
+// with its radius shrunk to stay concentric
+func (r roundedRect) inset(width int) roundedRect {
+	return roundedRect{
+		rect:   r.rect.Inset(width),
+		radius: math.Max(0, r.radius-float64(width)),
+	}
+}
+
+// fillRoundedRect fills r with c on dst
+func fillRoundedRect(dst *image.RGBA, r roundedRect, c color.Color) {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	bounds := r.rect.Intersect(dst.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r.contains(x, y) {
+				dst.SetRGBA(x, y, rgba)
+			}
+		}
+	}
+}
+
+// strokeRoundedRect draws a border width pixels wide just
+// inside the edge of r with c on dst
+func strokeRoundedRect(dst *image.RGBA, r roundedRect, width int, c color.Color) {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	inner := r.inset(width)
+	bounds := r.rect.Intersect(dst.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r.contains(x, y) && !inner.contains(x, y) {
+				dst.SetRGBA(x, y, rgba)
+			}
+		}
+	}
+}
+
+// invertRect swaps black and white within r, so dark text on a
+// light background becomes light on dark. Greys are inverted
+// too, red is left as it is.
+func invertRect(dst *image.RGBA, r roundedRect) {
+	bounds := r.rect.Intersect(dst.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !r.contains(x, y) {
+				continue
+			}
+			c := dst.RGBAAt(x, y)
+			if isRedish(c) {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{255 - c.R, 255 - c.G, 255 - c.B, c.A})
+		}
+	}
+}
+
+// isRedish reports whether c is closer to red than grey,
+// including anti-aliased red text on black or white
+func isRedish(c color.RGBA) bool {
+	return int(c.R)-int(c.G) > 64 && int(c.R)-int(c.B) > 64
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,203 @@
+package epd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/kjk/flex"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// debugLabelSize is the size in pixels of the ids drawn by
+// the debug overlay
+const debugLabelSize = 8
+
+// LayoutBox is a node as laid out by the renderer, for finding
+// out why a template looks the way it does
+type LayoutBox struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+	// X, Y, W and H are the node's box in pixels, including its
+	// border and padding, with X and Y from the top left of the image
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+	// Padding is the top, right, bottom and left border and
+	// padding inside the box
+	Padding [4]int `json:"padding"`
+	// Content is the kind of content drawn in the box:
+	// text, image, svg, code, chart or table
+	Content string `json:"content,omitempty"`
+	// FontSize is the font size of text, after fitting
+	FontSize float64 `json:"fontSize,omitempty"`
+	// Lines are the lines text was wrapped to, as drawn
+	Lines []string `json:"lines,omitempty"`
+	// Overflow is true when the text had more lines than fit
+	Overflow bool         `json:"overflow,omitempty"`
+	Children []*LayoutBox `json:"children,omitempty"`
+}
+
+// Layout lays content out in the template as Render would,
+// and returns the boxes of the nodes instead of an image
+func (r flexRenderEngine) Layout(content RenderContent, width, height int, layout RenderTemplate) (box *LayoutBox, err error) {
+	flexNode, err := r.layout(content, width, height, layout)
+	if err != nil {
+		return
+	}
+	return r.layoutBox(flexNode, image.Point{}), nil
+}
+
+func (r flexRenderEngine) layoutBox(flexNode *flex.Node, offset image.Point) (box *LayoutBox) {
+
+	node := flexNode.Context.(*Node)
+	rect := boxRect(flexNode, offset)
+	inner := contentRect(flexNode, offset)
+
+	box = &LayoutBox{
+		ID:   node.ID,
+		Type: node.Type,
+		X:    rect.Min.X,
+		Y:    rect.Min.Y,
+		W:    rect.Dx(),
+		H:    rect.Dy(),
+		Padding: [4]int{
+			inner.Min.Y - rect.Min.Y,
+			rect.Max.X - inner.Max.X,
+			rect.Max.Y - inner.Max.Y,
+			inner.Min.X - rect.Min.X,
+		},
+	}
+
+	switch x := node.Content.(type) {
+	case string:
+		if node.code != nil {
+			box.Content = "code"
+			break
+		}
+		style := node.TextStyle()
+		box.Content = "text"
+		// truetype faces of size 0 are 12pt
+		if box.FontSize = style.FontScale * r.fontSize; box.FontSize == 0 {
+			box.FontSize = 12
+		}
+		box.Lines, box.Overflow = r.textLines(x, style, inner)
+	case image.Image:
+		box.Content = "image"
+	case *svgImage:
+		box.Content = "svg"
+	case nil:
+	default:
+		if node.isChart() {
+			box.Content = "chart"
+		} else if node.Type == NodeTypeTable {
+			box.Content = "table"
+		}
+	}
+
+	for _, child := range flexNode.Children {
+		box.Children = append(box.Children, r.layoutBox(child, rect.Min))
+	}
+	return
+}
+
+// textLines are the lines text is drawn as in bounds
+func (r flexRenderEngine) textLines(text string, style TextStyle, bounds image.Rectangle) (lines []string, overflow bool) {
+	if style.Format == TextFormatMarkdown {
+		layout := r.layoutMarkdown(text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+		for _, line := range layout.lines {
+			var b strings.Builder
+			for _, run := range line.runs {
+				b.WriteString(run.text)
+			}
+			lines = append(lines, b.String())
+		}
+		return lines, layout.overflow
+	}
+	layout := layoutText(r.textFace(style), text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+	for _, line := range layout.lines {
+		lines = append(lines, line.text)
+	}
+	return lines, layout.overflow
+}
+
+// drawDebug draws the box of each node in red, the edge of
+// its padding dotted, and its id and size in the bottom right
+// corner, where it's least likely to hide the node's content.
+// Nodes that collapsed to nothing are marked with a cross, as
+// there's no box to see.
+func (r flexRenderEngine) drawDebug(flexNode *flex.Node, offset image.Point, dst *image.RGBA) {
+
+	node := flexNode.Context.(*Node)
+	rect := boxRect(flexNode, offset)
+	inner := contentRect(flexNode, offset)
+
+	if rect.Empty() {
+		for d := -3; d <= 3; d++ {
+			dst.Set(rect.Min.X+d, rect.Min.Y+d, ColorRed)
+			dst.Set(rect.Min.X+d, rect.Min.Y-d, ColorRed)
+		}
+	} else {
+		outlineRect(dst, rect, 1, ColorRed)
+		if inner != rect && !inner.Empty() {
+			outlineRect(dst, inner, 2, ColorRed)
+		}
+	}
+
+	label := node.ID
+	if label == "" {
+		label = node.Type
+	}
+	if label != "" {
+		r.drawDebugLabel(fmt.Sprintf("%s %dx%d", label, rect.Dx(), rect.Dy()), rect.Max, dst)
+	}
+
+	for _, child := range flexNode.Children {
+		r.drawDebug(child, rect.Min, dst)
+	}
+}
+
+// drawDebugLabel draws text in red on white with its bottom
+// right at pt, kept on the image
+func (r flexRenderEngine) drawDebugLabel(text string, pt image.Point, dst *image.RGBA) {
+
+	face := r.fonts.Face(FontFamilyMono, FontWeightNormal, FontStyleNormal, debugLabelSize*72/r.dpi, r.dpi)
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil() + 2
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	bounds := dst.Bounds()
+	pt.X = max(min(pt.X, bounds.Max.X), bounds.Min.X+width) - width
+	pt.Y = max(min(pt.Y, bounds.Max.Y), bounds.Min.Y+height) - height
+
+	background := image.Rect(pt.X, pt.Y, pt.X+width, pt.Y+height)
+	fillRoundedRect(dst, roundedRect{rect: background}, ColorWhite)
+
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(ColorRed),
+		Face: face,
+		Dot:  fixed.P(pt.X+1, pt.Y).Add(fixed.Point26_6{Y: metrics.Ascent}),
+	}
+	drawer.DrawString(text)
+}
+
+// outlineRect draws the inside edge of a rectangle, with a gap
+// between every dot pixels if dot is more than 1
+func outlineRect(dst *image.RGBA, rect image.Rectangle, dot int, c color.Color) {
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		if (x-rect.Min.X)%dot == 0 {
+			dst.Set(x, rect.Min.Y, c)
+			dst.Set(x, rect.Max.Y-1, c)
+		}
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		if (y-rect.Min.Y)%dot == 0 {
+			dst.Set(rect.Min.X, y, c)
+			dst.Set(rect.Max.X-1, y, c)
+		}
+	}
+}
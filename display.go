@@ -3,6 +3,7 @@ package epd
 import (
 	"image"
 	"strings"
+	"sync"
 )
 
 // Orientation represents a screen orientation
@@ -56,8 +57,16 @@ type Renderer interface {
 	Render(content RenderContent, width, height int, layout RenderTemplate) (img image.Image, err error)
 }
 
+// Layouter is implemented by renderers that can report how
+// they lay content out in a template, for debugging templates.
+type Layouter interface {
+	Layout(content RenderContent, width, height int, layout RenderTemplate) (box *LayoutBox, err error)
+}
+
 // Display represents the abstract high-level functions
-// that can be called on an attached E-paper display
+// that can be called on an attached E-paper display.
+// Implementations must be safe for concurrent use, serializing
+// access to the panel so that updates can't interleave.
 type Display interface {
 	// Show will use the default template and renderer to update
 	// the display
@@ -75,6 +84,29 @@ type Display interface {
 	Height() int
 }
 
+// Frame is content that has been rendered and converted into
+// the pixel buffers of a particular panel, ready to be sent to it.
+type Frame struct {
+	Black []byte
+	Red   []byte
+}
+
+// FrameDisplay is implemented by displays that can split an
+// update into rendering a frame and showing it on the panel.
+// Rendering doesn't touch the panel, so the next frame can be
+// rendered while the panel is still refreshing the last one.
+type FrameDisplay interface {
+	Display
+	// DefaultTemplate returns the template used by Show.
+	DefaultTemplate() RenderTemplate
+	// RenderFrame renders content with the template into a
+	// frame for this display.
+	RenderFrame(content RenderContent, tpl RenderTemplate) (frame Frame, err error)
+	// ShowFrame sends the frame to the panel, returning once the
+	// panel has finished refreshing.
+	ShowFrame(frame Frame) (err error)
+}
+
 // RenderOpts are used to tell the Display what renderer
 // to use, and what render tempalte to use by default
 type RenderOpts struct {
@@ -85,10 +117,35 @@ type RenderOpts struct {
 // epd is a base struct with common properties for
 // e-paper displays. Types implementing Display interface
 // can use this as a base.
+// mu guards the panel and is shared by all copies of
+// the display, so it must be held for the whole of any
+// sequence of commands sent to the panel.
 type epd struct {
 	RendererOpts RenderOpts
 	width        int
 	height       int
 	orientation  Orientation
 	driver       Driver
+	stats        *statsTracker
+	mu           *sync.Mutex
+}
+
+// Stats returns a snapshot of the panel's refresh statistics.
+func (e epd) Stats() PanelStats {
+	return e.stats.snapshot()
+}
+
+// SetStatsFile persists the panel's refresh statistics to the
+// json file at path, adding to any already recorded there.
+func (e epd) SetStatsFile(path string) (err error) {
+	return e.stats.setFile(path)
+}
+
+// panelName names a panel for its stats by its model and
+// the spi bus it is attached to.
+func panelName(model, spiAddress string) string {
+	if spiAddress == "" {
+		spiAddress = "default"
+	}
+	return model + "@" + spiAddress
 }
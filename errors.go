@@ -0,0 +1,51 @@
+package epd
+
+import (
+	"fmt"
+)
+
+// TemplateError is returned when a template can't be parsed or
+// used. Path is a json path to the part of the template at fault,
+// such as `$.children[1].fontSize`.
+type TemplateError struct {
+	Path string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("Template error at %s: %s", e.Path, e.Err.Error())
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// ContentError is returned when the content for a node can't
+// be rendered. ID is the id of the content in the RenderContent.
+type ContentError struct {
+	ID  string
+	Err error
+}
+
+func (e *ContentError) Error() string {
+	return fmt.Sprintf("Content error for %s: %s", e.ID, e.Err.Error())
+}
+
+func (e *ContentError) Unwrap() error {
+	return e.Err
+}
+
+// DriverError is returned when talking to the panel fails.
+// Op is the operation that was being carried out.
+type DriverError struct {
+	Op  string
+	Err error
+}
+
+func (e *DriverError) Error() string {
+	return fmt.Sprintf("Driver error during %s: %s", e.Op, e.Err.Error())
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
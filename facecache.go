@@ -0,0 +1,125 @@
+package epd
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// maxCachedFaces is how many faces a font registry keeps
+	maxCachedFaces = 64
+	// maxCachedWraps is how many wrapped texts a face keeps
+	maxCachedWraps = 256
+)
+
+// faceKey identifies a face in a font registry's cache
+type faceKey struct {
+	family  string
+	weight  int
+	style   string
+	size    float64
+	dpi     float64
+	hinting font.Hinting
+}
+
+// wrapKey identifies text wrapped to a width in a face
+type wrapKey struct {
+	text          string
+	width         float64
+	letterSpacing float64
+}
+
+// cachedFace is a face shared by all text of its font and size.
+// Faces keep state while drawing, so it's guarded by a lock.
+// Glyph advances are remembered, as truetype faces load the
+// glyph to find them, along with the lines text was wrapped to,
+// as flex measures nodes over and over while laying them out.
+type cachedFace struct {
+	mu       sync.Mutex
+	face     font.Face
+	metrics  font.Metrics
+	advances map[rune]glyphAdvance
+	wraps    map[wrapKey][]string
+}
+
+type glyphAdvance struct {
+	advance fixed.Int26_6
+	ok      bool
+}
+
+func newCachedFace(face font.Face) *cachedFace {
+	return &cachedFace{
+		face:     face,
+		metrics:  face.Metrics(),
+		advances: make(map[rune]glyphAdvance),
+		wraps:    make(map[wrapKey][]string),
+	}
+}
+
+// Close does nothing, the face is shared
+func (f *cachedFace) Close() error {
+	return nil
+}
+
+// Glyph returns a copy of the glyph's mask, as faces reuse
+// their mask for the next glyph
+func (f *cachedFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dr, glyphMask, glyphp, advance, ok := f.face.Glyph(dot, r)
+	if !ok || glyphMask == nil {
+		return
+	}
+	copied := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	draw.Draw(copied, copied.Bounds(), glyphMask, glyphp, draw.Src)
+	return dr, copied, image.Point{}, advance, ok
+}
+
+func (f *cachedFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.GlyphBounds(r)
+}
+
+func (f *cachedFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, cached := f.advances[r]
+	if !cached {
+		a.advance, a.ok = f.face.GlyphAdvance(r)
+		f.advances[r] = a
+	}
+	return a.advance, a.ok
+}
+
+func (f *cachedFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.Kern(r0, r1)
+}
+
+func (f *cachedFace) Metrics() font.Metrics {
+	return f.metrics
+}
+
+// wrapped returns the lines text was last wrapped to, if it
+// was wrapped in the face before
+func (f *cachedFace) wrapped(key wrapKey) (lines []string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, ok = f.wraps[key]
+	return
+}
+
+func (f *cachedFace) setWrapped(key wrapKey, lines []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.wraps) >= maxCachedWraps {
+		f.wraps = make(map[wrapKey][]string)
+	}
+	f.wraps[key] = lines
+}
@@ -1,10 +1,14 @@
 package epd
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/jinzhu/copier"
 	"github.com/kjk/flex"
@@ -35,11 +39,11 @@ var FlexAlignMap = map[string]flex.Align{
 	flex.AlignToString(flex.AlignAuto): flex.AlignAuto,
 	// AlignFlexStart is "flex-start"
 	flex.AlignToString(flex.AlignFlexStart): flex.AlignFlexStart,
-	// AlignCenter if "center"
+	// AlignCenter is "center"
 	flex.AlignToString(flex.AlignCenter): flex.AlignCenter,
 	// AlignFlexEnd is "flex-end"
 	flex.AlignToString(flex.AlignFlexEnd): flex.AlignFlexEnd,
-	// AlignStretch is "strech"
+	// AlignStretch is "stretch"
 	flex.AlignToString(flex.AlignStretch): flex.AlignStretch,
 	// AlignBaseline is "baseline"
 	flex.AlignToString(flex.AlignBaseline): flex.AlignBaseline,
@@ -49,6 +53,11 @@ var FlexAlignMap = map[string]flex.Align{
 	flex.AlignToString(flex.AlignSpaceAround): flex.AlignSpaceAround,
 }
 
+var FlexPositionTypeMap = map[string]flex.PositionType{
+	flex.PositionTypeToString(flex.PositionTypeRelative): flex.PositionTypeRelative,
+	flex.PositionTypeToString(flex.PositionTypeAbsolute): flex.PositionTypeAbsolute,
+}
+
 func FlexAlignFromString(align string) flex.Align {
 	if a, ok := FlexAlignMap[align]; ok {
 		return a
@@ -70,6 +79,13 @@ func FlexWrapFromString(wrap string) flex.Wrap {
 	return flex.WrapNoWrap
 }
 
+func FlexPositionTypeFromString(position string) flex.PositionType {
+	if p, ok := FlexPositionTypeMap[position]; ok {
+		return p
+	}
+	return flex.PositionTypeRelative
+}
+
 func FlexJustifyFromString(justify string) flex.Justify {
 	if j, ok := FlexJustifyMap[justify]; ok {
 		return j
@@ -77,28 +93,161 @@ func FlexJustifyFromString(justify string) flex.Justify {
 	return flex.JustifyFlexStart
 }
 
+// Dimension is a length in a template. It can be a number of
+// pixels, a percentage of the parent such as "50%", or "auto".
+// Numbers can be given with or without quotes. An empty
+// Dimension is unset.
+type Dimension string
+
+func (d *Dimension) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+	case string:
+		*d = Dimension(v)
+	case float64:
+		*d = Dimension(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return &json.UnmarshalTypeError{
+			Value: fmt.Sprintf("%T", v),
+			Type:  reflect.TypeOf(*d),
+		}
+	}
+	return nil
+}
+
+// Value returns the dimension as a flex value. Unset and
+// unparsable dimensions are undefined.
+func (d Dimension) Value() flex.Value {
+	s := strings.TrimSpace(strings.ToLower(string(d)))
+	switch {
+	case s == "":
+		return flex.Value{Unit: flex.UnitUndefined}
+	case s == "auto":
+		return flex.Value{Unit: flex.UnitAuto}
+	case strings.HasSuffix(s, "%"):
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 32); err == nil {
+			return flex.Value{Value: float32(f), Unit: flex.UnitPercent}
+		}
+	default:
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(s, "px"), 32); err == nil {
+			return flex.Value{Value: float32(f), Unit: flex.UnitPoint}
+		}
+	}
+	return flex.Value{Unit: flex.UnitUndefined}
+}
+
+// apply sets the dimension on a flex node with the setter for
+// its unit. Undefined dimensions, or units without a setter,
+// are left alone.
+func (d Dimension) apply(point, percent func(float32), auto func()) {
+	v := d.Value()
+	switch {
+	case v.Unit == flex.UnitPoint && point != nil:
+		point(v.Value)
+	case v.Unit == flex.UnitPercent && percent != nil:
+		percent(v.Value)
+	case v.Unit == flex.UnitAuto && auto != nil:
+		auto()
+	}
+}
+
 type NodeList []*Node
 
 type Node struct {
-	ID             string      `json:"id"`
-	Type           string      `json:"type"`
-	Children       NodeList    `json:"children"`
-	Content        interface{} `json:"content"`
-	FontSize       float64     `json:"fontSize"`
-	FontWeight     string      `json:"fontWeight"`
-	Width          int         `json:"width"`
-	Height         int         `json:"height"`
-	Padding        float32     `json:"padding"`
-	Margin         float32     `json:"margin"`
-	FlexDirection  string      `json:"flexDirection"`
-	FlexGrow       float32     `json:"flexGrow"`
-	FlexShrink     float32     `json:"flexShrink"`
-	FlexWrap       string      `json:"flexWrap"`
-	JustifyContent string      `json:"justifyContent"`
-	AlignItems     string      `json:"alignItems"`
-	AlignContent   string      `json:"alignContent"`
-	AlignSelf      string      `json:"alignSelf"`
-	FlexBasis      string      `json:"flexBasis"`
+	ID                    string        `json:"id"`
+	Type                  string        `json:"type"`
+	Children              NodeList      `json:"children"`
+	Content               interface{}   `json:"content"`
+	If                    string        `json:"if"`
+	Repeat                string        `json:"repeat"`
+	MaxItems              int           `json:"maxItems"`
+	More                  *Node         `json:"more"`
+	Columns               []TableColumn `json:"columns"`
+	CellPadding           float32       `json:"cellPadding"`
+	HeaderColor           string        `json:"headerColor"`
+	HeaderBackgroundColor string        `json:"headerBackgroundColor"`
+	StripeColor           string        `json:"stripeColor"`
+	RuleWidth             float32       `json:"ruleWidth"`
+	RuleColor             string        `json:"ruleColor"`
+	ErrorCorrection       string        `json:"errorCorrection"`
+	Symbology             string        `json:"symbology"`
+	QuietZone             *int          `json:"quietZone"`
+	ModuleSize            float32       `json:"moduleSize"`
+	Min                   *float64      `json:"min"`
+	Max                   *float64      `json:"max"`
+	Thresholds            []float64     `json:"thresholds"`
+	Labels                []string      `json:"labels"`
+	Markers               bool          `json:"markers"`
+	StrokeWidth           float32       `json:"strokeWidth"`
+	Icon                  string        `json:"icon"`
+	ObjectFit             string        `json:"objectFit"`
+	ObjectPosition        string        `json:"objectPosition"`
+	Brightness            float64       `json:"brightness"`
+	Contrast              float64       `json:"contrast"`
+	Gamma                 float64       `json:"gamma"`
+	Sharpen               float64       `json:"sharpen"`
+	Threshold             float64       `json:"threshold"`
+	FontSize              float64       `json:"fontSize"`
+	FontFamily            string        `json:"fontFamily"`
+	FontWeight            string        `json:"fontWeight"`
+	FontStyle             string        `json:"fontStyle"`
+	TextAlign             string        `json:"textAlign"`
+	VerticalAlign         string        `json:"verticalAlign"`
+	LineHeight            float64       `json:"lineHeight"`
+	LetterSpacing         float64       `json:"letterSpacing"`
+	MaxLines              int           `json:"maxLines"`
+	TextOverflow          string        `json:"textOverflow"`
+	Format                string        `json:"format"`
+	Fit                   string        `json:"fit"`
+	MinFontSize           float64       `json:"minFontSize"`
+	MaxFontSize           float64       `json:"maxFontSize"`
+	Color                 string        `json:"color"`
+	BackgroundColor       string        `json:"backgroundColor"`
+	BorderWidth           float32       `json:"borderWidth"`
+	BorderColor           string        `json:"borderColor"`
+	BorderRadius          float32       `json:"borderRadius"`
+	Invert                bool          `json:"invert"`
+	Width                 Dimension     `json:"width"`
+	Height                Dimension     `json:"height"`
+	MinWidth              Dimension     `json:"minWidth"`
+	MinHeight             Dimension     `json:"minHeight"`
+	MaxWidth              Dimension     `json:"maxWidth"`
+	MaxHeight             Dimension     `json:"maxHeight"`
+	Padding               Dimension     `json:"padding"`
+	PaddingTop            Dimension     `json:"paddingTop"`
+	PaddingRight          Dimension     `json:"paddingRight"`
+	PaddingBottom         Dimension     `json:"paddingBottom"`
+	PaddingLeft           Dimension     `json:"paddingLeft"`
+	Margin                Dimension     `json:"margin"`
+	MarginTop             Dimension     `json:"marginTop"`
+	MarginRight           Dimension     `json:"marginRight"`
+	MarginBottom          Dimension     `json:"marginBottom"`
+	MarginLeft            Dimension     `json:"marginLeft"`
+	Position              string        `json:"position"`
+	Top                   Dimension     `json:"top"`
+	Right                 Dimension     `json:"right"`
+	Bottom                Dimension     `json:"bottom"`
+	Left                  Dimension     `json:"left"`
+	FlexDirection         string        `json:"flexDirection"`
+	FlexGrow              float32       `json:"flexGrow"`
+	FlexShrink            float32       `json:"flexShrink"`
+	FlexWrap              string        `json:"flexWrap"`
+	JustifyContent        string        `json:"justifyContent"`
+	AlignItems            string        `json:"alignItems"`
+	AlignContent          string        `json:"alignContent"`
+	AlignSelf             string        `json:"alignSelf"`
+	FlexBasis             Dimension     `json:"flexBasis"`
+	// fittedFontSize is the font size chosen to fit the
+	// text to the node's box, when it has a fit mode
+	fittedFontSize float64
+	// code is the encoded content of qr and barcode nodes
+	code codeModules
+	// series are the numbers of chart nodes
+	series []float64
 }
 
 func DefaultNode() Node {
@@ -110,6 +259,8 @@ func DefaultNode() Node {
 		AlignItems:     flex.AlignToString(flex.AlignStretch),
 		AlignContent:   flex.AlignToString(flex.AlignStretch),
 		AlignSelf:      flex.AlignToString(flex.AlignAuto),
+		CellPadding:    4,
+		ObjectFit:      ObjectFitContain,
 		Children:       nil,
 	}
 }
@@ -125,6 +276,106 @@ func (n *Node) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// ParseTemplate parses a json template into its tree of nodes.
+// Any error is a *TemplateError with the json path of the node
+// or property at fault.
+func ParseTemplate(tpl RenderTemplate) (root *Node, err error) {
+	root = &Node{}
+	err = parseNode([]byte(tpl), "$", root)
+	return
+}
+
+// parseNode unmarshals a node, and then each of its children
+// separately so that errors can be traced to the child.
+func parseNode(data []byte, path string, node *Node) (err error) {
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return templateJSONError(data, path, err)
+	}
+
+	// encoding/json matches keys case insensitively,
+	// so children have to be found the same way
+	var children json.RawMessage
+	for key, value := range fields {
+		if strings.EqualFold(key, "children") {
+			children = value
+			delete(fields, key)
+		}
+	}
+
+	nodeData, err := json.Marshal(fields)
+	if err != nil {
+		return &TemplateError{Path: path, Err: err}
+	}
+	if err = json.Unmarshal(nodeData, node); err != nil {
+		return templateJSONError(nodeData, path, err)
+	}
+
+	if children == nil {
+		return
+	}
+
+	var rawChildren []json.RawMessage
+	if err = json.Unmarshal(children, &rawChildren); err != nil {
+		return templateJSONError(children, path+".children", err)
+	}
+
+	for idx, rawChild := range rawChildren {
+		child := &Node{}
+		if err = parseNode(rawChild, fmt.Sprintf("%s.children[%d]", path, idx), child); err != nil {
+			return
+		}
+		node.Children = append(node.Children, child)
+	}
+
+	return
+}
+
+func templateJSONError(data []byte, path string, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line := bytes.Count(data[:e.Offset], []byte("\n")) + 1
+		column := int(e.Offset) - bytes.LastIndexByte(data[:e.Offset], '\n') - 1
+		return &TemplateError{
+			Path: path,
+			Err:  fmt.Errorf("%s (line %d, column %d)", e.Error(), line, column),
+		}
+	case *json.UnmarshalTypeError:
+		if e.Field != "" {
+			path = path + "." + e.Field
+		}
+		return &TemplateError{
+			Path: path,
+			Err:  fmt.Errorf("expected %s but got %s", e.Type.String(), e.Value),
+		}
+	}
+	return &TemplateError{Path: path, Err: err}
+}
+
+// TextStyle returns the style text content of the node
+// is laid out with
+func (n *Node) TextStyle() TextStyle {
+	fontSize := n.FontSize
+	if n.fittedFontSize > 0 {
+		fontSize = n.fittedFontSize
+	}
+	return TextStyle{
+		FontScale:     fontSize,
+		FontFamily:    n.FontFamily,
+		FontWeight:    FontWeightFromString(n.FontWeight),
+		FontStyle:     FontStyleFromString(n.FontStyle),
+		Align:         n.TextAlign,
+		VerticalAlign: n.VerticalAlign,
+		LineHeight:    n.LineHeight,
+		LetterSpacing: n.LetterSpacing,
+		MaxLines:      n.MaxLines,
+		Overflow:      n.TextOverflow,
+		Format:        n.Format,
+		Color:         ColorFromString(n.Color),
+	}
+}
+
 var ErrNodeNotFound = errors.New("Node not found")
 
 var PatternInt = regexp.MustCompile(`^\d+$`)
@@ -173,8 +424,6 @@ func (n *Node) Inflate(config ...*flex.Config) (flexNode *flex.Node) {
 
 	}
 
-	hasContent := n.Content != nil
-
 	flexNode.StyleSetFlexDirection(FlexDirectionFromString(n.FlexDirection))
 	flexNode.StyleSetFlexGrow(n.FlexGrow)
 	flexNode.StyleSetFlexShrink(n.FlexShrink)
@@ -183,16 +432,57 @@ func (n *Node) Inflate(config ...*flex.Config) (flexNode *flex.Node) {
 	flexNode.StyleSetAlignItems(FlexAlignFromString(n.AlignItems))
 	flexNode.StyleSetAlignSelf(FlexAlignFromString(n.AlignSelf))
 	flexNode.StyleSetJustifyContent(FlexJustifyFromString(n.JustifyContent))
-	if hasContent {
-		flexNode.StyleSetPadding(flex.EdgeAll, n.Padding)
-	} else {
-		flexNode.StyleSetPadding(flex.EdgeAll, 0)
-	}
-	if PatternInt.Match([]byte(n.FlexBasis)) {
-		i, _ := strconv.Atoi(n.FlexBasis)
-		flexNode.StyleSetFlexBasis(float32(i))
-	} else { // TODO add percent switch
+	flexNode.StyleSetBorder(flex.EdgeAll, n.BorderWidth)
+	flexNode.StyleSetPositionType(FlexPositionTypeFromString(n.Position))
+
+	n.Width.apply(flexNode.StyleSetWidth, flexNode.StyleSetWidthPercent, flexNode.StyleSetWidthAuto)
+	n.Height.apply(flexNode.StyleSetHeight, flexNode.StyleSetHeightPercent, flexNode.StyleSetHeightAuto)
+	n.MinWidth.apply(flexNode.StyleSetMinWidth, flexNode.StyleSetMinWidthPercent, nil)
+	n.MinHeight.apply(flexNode.StyleSetMinHeight, flexNode.StyleSetMinHeightPercent, nil)
+	n.MaxWidth.apply(flexNode.StyleSetMaxWidth, flexNode.StyleSetMaxWidthPercent, nil)
+	n.MaxHeight.apply(flexNode.StyleSetMaxHeight, flexNode.StyleSetMaxHeightPercent, nil)
+	n.FlexBasis.apply(flexNode.StyleSetFlexBasis, flexNode.StyleSetFlexBasisPercent, func() {
 		flex.NodeStyleSetFlexBasisAuto(flexNode)
+	})
+
+	// Nodes with no content and no children have nothing to pad,
+	// so the empty title and footer of a template take no space
+	empty := n.Content == nil && len(n.Children) == 0
+
+	// Edges are set for all sides first, so a single side
+	// can override them
+	edges := []struct {
+		edge                      flex.Edge
+		padding, margin, position Dimension
+	}{
+		{flex.EdgeAll, n.Padding, n.Margin, ""},
+		{flex.EdgeTop, n.PaddingTop, n.MarginTop, n.Top},
+		{flex.EdgeRight, n.PaddingRight, n.MarginRight, n.Right},
+		{flex.EdgeBottom, n.PaddingBottom, n.MarginBottom, n.Bottom},
+		{flex.EdgeLeft, n.PaddingLeft, n.MarginLeft, n.Left},
+	}
+	for _, e := range edges {
+		edge := e.edge
+		if empty {
+			e.padding = ""
+		}
+		e.padding.apply(func(v float32) {
+			flexNode.StyleSetPadding(edge, v)
+		}, func(v float32) {
+			flexNode.StyleSetPaddingPercent(edge, v)
+		}, nil)
+		e.margin.apply(func(v float32) {
+			flexNode.StyleSetMargin(edge, v)
+		}, func(v float32) {
+			flexNode.StyleSetMarginPercent(edge, v)
+		}, func() {
+			flexNode.StyleSetMarginAuto(edge)
+		})
+		e.position.apply(func(v float32) {
+			flexNode.StyleSetPosition(edge, v)
+		}, func(v float32) {
+			flexNode.StyleSetPositionPercent(edge, v)
+		}, nil)
 	}
 	flexNode.StyleSetAlignSelf(FlexAlignFromString(n.AlignSelf))
 
@@ -0,0 +1,508 @@
+package epd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"io/fs"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/golang/freetype/truetype"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomediumitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// FontFamilyDefault is the family used when a node doesn't
+	// name one, or names one that isn't registered.
+	FontFamilyDefault = "default"
+	// FontFamilyGo is the Go font family, always registered
+	FontFamilyGo = "go"
+	// FontFamilyMono is the Go Mono font family, always registered
+	FontFamilyMono = "mono"
+
+	FontStyleNormal = "normal"
+	FontStyleItalic = "italic"
+)
+
+// Font weights as in css. Names and numbers can be used in
+// templates, `"fontWeight": "bold"` is the same as 700.
+const (
+	FontWeightThin       = 100
+	FontWeightExtraLight = 200
+	FontWeightLight      = 300
+	FontWeightNormal     = 400
+	FontWeightMedium     = 500
+	FontWeightSemiBold   = 600
+	FontWeightBold       = 700
+	FontWeightExtraBold  = 800
+	FontWeightBlack      = 900
+)
+
+var fontWeightNames = map[string]int{
+	"thin":       FontWeightThin,
+	"hairline":   FontWeightThin,
+	"extralight": FontWeightExtraLight,
+	"ultralight": FontWeightExtraLight,
+	"light":      FontWeightLight,
+	"normal":     FontWeightNormal,
+	"regular":    FontWeightNormal,
+	"book":       FontWeightNormal,
+	"medium":     FontWeightMedium,
+	"semibold":   FontWeightSemiBold,
+	"demibold":   FontWeightSemiBold,
+	"bold":       FontWeightBold,
+	"extrabold":  FontWeightExtraBold,
+	"ultrabold":  FontWeightExtraBold,
+	"black":      FontWeightBlack,
+	"heavy":      FontWeightBlack,
+}
+
+// FontWeightFromString maps a css style weight name or number
+// to its weight. It is case insensitive. If the weight isn't
+// recognised it will default to normal.
+func FontWeightFromString(weight string) int {
+	weight = strings.ToLower(strings.Replace(strings.Replace(weight, "-", "", -1), " ", "", -1))
+	if w, ok := fontWeightNames[weight]; ok {
+		return w
+	}
+	if w, err := strconv.Atoi(weight); err == nil && w > 0 && w < 1000 {
+		return w
+	}
+	return FontWeightNormal
+}
+
+// FontStyleFromString maps "italic" or "oblique" to italic,
+// anything else to normal.
+func FontStyleFromString(style string) string {
+	switch strings.ToLower(style) {
+	case "italic", "oblique":
+		return FontStyleItalic
+	}
+	return FontStyleNormal
+}
+
+// FontSource provides faces of a font.
+type FontSource interface {
+	// Face returns a face for the font at size points and dpi.
+	// Bitmap fonts can't be freely scaled, so their faces
+	// may not be exactly the size asked for.
+	Face(size, dpi float64) font.Face
+}
+
+// TrueTypeFont is a scalable font source
+type TrueTypeFont struct {
+	Font *truetype.Font
+	// Hinting snaps glyphs to the pixel grid. The default,
+	// none, keeps their shapes.
+	Hinting font.Hinting
+	// data is the font file, for formats that embed fonts
+	data []byte
+}
+
+// ParseTrueType parses a ttf font, or the first font in a ttc
+// collection.
+func ParseTrueType(data []byte) (f TrueTypeFont, err error) {
+	f.Font, err = truetype.Parse(data)
+	f.data = data
+	return
+}
+
+// embeddable returns the font file of a ttf font, for output
+// formats that embed the fonts they use. Collections and
+// bitmap fonts can't be embedded.
+func embeddable(source FontSource) (data []byte, ok bool) {
+	tt, ok := source.(TrueTypeFont)
+	if !ok || len(tt.data) < 4 || string(tt.data[:4]) == "ttcf" {
+		return nil, false
+	}
+	return tt.data, true
+}
+
+// Face returns a face of the font. A size of 0 is 12 points.
+func (f TrueTypeFont) Face(size, dpi float64) font.Face {
+	return truetype.NewFace(f.Font, &truetype.Options{
+		Size:       size,
+		DPI:        dpi,
+		Hinting:    f.Hinting,
+		SubPixelsX: 16,
+		SubPixelsY: 16,
+	})
+}
+
+type fontKey struct {
+	weight int
+	style  string
+}
+
+// FontRegistry holds fonts by family, weight and style so that
+// templates can ask for them by name. Fonts can be TrueType
+// or BDF and PCF bitmap fonts, which stay crisp at small sizes
+// on e-paper where anti-aliased text turns ragged.
+// It is safe for concurrent use.
+type FontRegistry struct {
+	mu       sync.RWMutex
+	families map[string]map[fontKey]FontSource
+	// faces are the faces asked for so far, which are
+	// forgotten when a font is registered
+	faces map[faceKey]*cachedFace
+}
+
+// NewFontRegistry returns a registry with the Go and Go Mono
+// font families registered as "go" and "mono". The "go" family
+// is also the default until another default is registered.
+func NewFontRegistry() *FontRegistry {
+	r := &FontRegistry{
+		families: make(map[string]map[fontKey]FontSource),
+		faces:    make(map[faceKey]*cachedFace),
+	}
+	builtins := []struct {
+		family string
+		weight int
+		style  string
+		data   []byte
+	}{
+		{FontFamilyGo, FontWeightNormal, FontStyleNormal, goregular.TTF},
+		{FontFamilyGo, FontWeightNormal, FontStyleItalic, goitalic.TTF},
+		{FontFamilyGo, FontWeightMedium, FontStyleNormal, gomedium.TTF},
+		{FontFamilyGo, FontWeightMedium, FontStyleItalic, gomediumitalic.TTF},
+		{FontFamilyGo, FontWeightBold, FontStyleNormal, gobold.TTF},
+		{FontFamilyGo, FontWeightBold, FontStyleItalic, gobolditalic.TTF},
+		{FontFamilyMono, FontWeightNormal, FontStyleNormal, gomono.TTF},
+		{FontFamilyMono, FontWeightNormal, FontStyleItalic, gomonoitalic.TTF},
+		{FontFamilyMono, FontWeightBold, FontStyleNormal, gomonobold.TTF},
+		{FontFamilyMono, FontWeightBold, FontStyleItalic, gomonobolditalic.TTF},
+	}
+	for _, builtin := range builtins {
+		// The go fonts are known to parse
+		f, _ := ParseTrueType(builtin.data)
+		r.Register(builtin.family, builtin.weight, builtin.style, f)
+	}
+	return r
+}
+
+// Register adds a font to a family. Family names are case
+// insensitive.
+func (r *FontRegistry) Register(family string, weight int, style string, source FontSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family = strings.ToLower(family)
+	if r.families[family] == nil {
+		r.families[family] = make(map[fontKey]FontSource)
+	}
+	r.families[family][fontKey{weight, FontStyleFromString(style)}] = source
+	r.faces = make(map[faceKey]*cachedFace)
+}
+
+// Families returns the names of the registered font families
+func (r *FontRegistry) Families() (families []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for family := range r.families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return
+}
+
+// Source returns the registered font closest to the family,
+// weight and style asked for. Unknown families fall back to
+// the default family, then the go family. Within a family the
+// closest weight in the right style is preferred.
+func (r *FontRegistry) Source(family string, weight int, style string) FontSource {
+	source, _ := r.sources(family, weight, style)
+	return source
+}
+
+// sourceFor is the font text is set in. It's the go family font
+// of the weight and style asked for when the default family has
+// no such font and the go font has all of the text's glyphs.
+func (r *FontRegistry) sourceFor(family string, weight int, style string, text string) FontSource {
+	source, styled := r.sources(family, weight, style)
+	if styled != nil && hasGlyphs(styled, text) {
+		return styled
+	}
+	return source
+}
+
+// sources returns the closest font, and a styled go font to set
+// the glyphs it has in if the family is the default and has no
+// bold or italic where one is asked for. The default family is
+// often a single font, such as the bundled CJK font, so glyphs
+// the go font doesn't have are still set in the closest font.
+func (r *FontRegistry) sources(family string, weight int, style string) (source FontSource, styled FontSource) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	family = strings.ToLower(family)
+	fonts, ok := r.families[family]
+	if !ok {
+		family = FontFamilyDefault
+		if fonts, ok = r.families[FontFamilyDefault]; !ok {
+			fonts = r.families[FontFamilyGo]
+		}
+	}
+
+	if weight <= 0 {
+		weight = FontWeightNormal
+	}
+	style = FontStyleFromString(style)
+	source, ok = closestFont(fonts, weight, style)
+	if !ok && family == FontFamilyDefault {
+		if goFont, ok := closestFont(r.families[FontFamilyGo], weight, style); ok && goFont != source {
+			styled = goFont
+		}
+	}
+	return
+}
+
+// hasGlyphs reports whether a truetype font has glyphs for all of text
+func hasGlyphs(source FontSource, text string) bool {
+	tt, ok := source.(TrueTypeFont)
+	if !ok {
+		return false
+	}
+	for _, char := range text {
+		if tt.Font.Index(char) == 0 && !unicode.IsSpace(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// closestFont picks the font closest to a weight and style, and
+// whether it has that style and is bold when bold is asked for
+func closestFont(fonts map[fontKey]FontSource, weight int, style string) (best FontSource, ok bool) {
+	var bestKey fontKey
+	bestScore := math.MaxInt32
+	for key, source := range fonts {
+		score := abs(key.weight - weight)
+		if key.style != style {
+			score += 1000
+		}
+		// Ties go to the heavier weight for bold
+		// and the lighter for normal, as in css
+		if score < bestScore || (score == bestScore && (key.weight > weight) == (weight > FontWeightNormal)) {
+			best = source
+			bestKey = key
+			bestScore = score
+		}
+	}
+	ok = best != nil && bestKey.style == style &&
+		(bestKey.weight >= FontWeightSemiBold) == (weight >= FontWeightSemiBold)
+	return
+}
+
+// Face returns a face for the closest registered font. Faces
+// are cached by font, size and hinting, and shared by everyone
+// asking for the same one, so they're safe for concurrent use
+// and must not be closed.
+func (r *FontRegistry) Face(family string, weight int, style string, size, dpi float64) font.Face {
+
+	source, styled := r.sources(family, weight, style)
+	key := faceKey{
+		family: strings.ToLower(family),
+		weight: weight,
+		style:  FontStyleFromString(style),
+		size:   size,
+		dpi:    dpi,
+	}
+	if tt, ok := source.(TrueTypeFont); ok {
+		key.hinting = tt.Hinting
+	}
+
+	r.mu.RLock()
+	face, ok := r.faces[key]
+	r.mu.RUnlock()
+	if ok {
+		return face
+	}
+
+	sourceFace := source.Face(size, dpi)
+	if tt, ok := styled.(TrueTypeFont); ok {
+		sourceFace = &styledFace{Face: sourceFace, styled: tt.Face(size, dpi), font: tt.Font}
+	}
+	face = newCachedFace(sourceFace)
+	r.mu.Lock()
+	// Fitting text tries many sizes, so don't keep them all
+	if len(r.faces) >= maxCachedFaces {
+		r.faces = make(map[faceKey]*cachedFace)
+	}
+	r.faces[key] = face
+	r.mu.Unlock()
+	return face
+}
+
+// LoadFont parses font data as TrueType, BDF or PCF, going by
+// the file name's extension. PCF fonts may be gzipped.
+func LoadFont(name string, data []byte) (source FontSource, err error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".gz" {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return
+		}
+		ext = strings.ToLower(path.Ext(strings.TrimSuffix(name, path.Ext(name))))
+	}
+	switch ext {
+	case ".ttf", ".ttc":
+		return ParseTrueType(data)
+	case ".bdf":
+		return ParseBDF(data)
+	case ".pcf":
+		return ParsePCF(data)
+	}
+	return nil, fmt.Errorf("Unsupported font format %s", name)
+}
+
+// LoadFile loads a font file into a family
+func (r *FontRegistry) LoadFile(family string, weight int, style string, file string) (err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	source, err := LoadFont(file, data)
+	if err != nil {
+		return
+	}
+	r.Register(family, weight, style, source)
+	return
+}
+
+// LoadDir loads all the fonts in a directory and its sub
+// directories. See LoadFS for how fonts are named.
+func (r *FontRegistry) LoadDir(dir string) (err error) {
+	return r.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS loads all the fonts under dir in fsys, which could be
+// an embed.FS. The family, weight and style of each font come
+// from its file name, which should be the family followed by
+// any weight and style, separated by dashes. So
+// `mono-bold-italic.ttf` is the bold italic of the "mono"
+// family, and `Terminus-12.pcf.gz` is the normal "terminus-12"
+// family.
+func (r *FontRegistry) LoadFS(fsys fs.FS, dir string) (err error) {
+	return fs.WalkDir(fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		source, err := LoadFont(name, data)
+		if err != nil {
+			// Not every file alongside the fonts is a font
+			log.Debugf("Skipping %s: %s", name, err.Error())
+			return nil
+		}
+		family, weight, style := fontNameParts(filepath.Base(name))
+		r.Register(family, weight, style, source)
+		return nil
+	})
+}
+
+// fontNameParts splits a font file name into family, weight
+// and style.
+func fontNameParts(name string) (family string, weight int, style string) {
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, path.Ext(name))
+	parts := strings.Split(strings.ToLower(name), "-")
+
+	weight = FontWeightNormal
+	style = FontStyleNormal
+	for len(parts) > 1 {
+		last := parts[len(parts)-1]
+		if strings.HasSuffix(last, "italic") || last == "oblique" {
+			style = FontStyleItalic
+			last = strings.TrimSuffix(last, "italic")
+			if last == "" || last == "oblique" {
+				parts = parts[:len(parts)-1]
+				continue
+			}
+		}
+		if w, ok := fontWeightNames[last]; ok {
+			weight = w
+			parts = parts[:len(parts)-1]
+			continue
+		}
+		break
+	}
+	family = strings.Join(parts, "-")
+	return
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// styledFace sets the glyphs a styled font has in it, and the rest
+// in the face it's standing in for, whose metrics it keeps
+type styledFace struct {
+	font.Face
+	styled font.Face
+	font   *truetype.Font
+}
+
+func (f *styledFace) face(r rune) font.Face {
+	if f.font.Index(r) != 0 {
+		return f.styled
+	}
+	return f.Face
+}
+
+func (f *styledFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	return f.face(r).Glyph(dot, r)
+}
+
+func (f *styledFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	return f.face(r).GlyphBounds(r)
+}
+
+func (f *styledFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	return f.face(r).GlyphAdvance(r)
+}
+
+func (f *styledFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	styled0, styled1 := f.font.Index(r0) != 0, f.font.Index(r1) != 0
+	if styled0 != styled1 {
+		return 0
+	}
+	return f.face(r0).Kern(r0, r1)
+}
+
+func (f *styledFace) Close() error {
+	f.styled.Close()
+	return f.Face.Close()
+}
@@ -0,0 +1,96 @@
+package epd
+
+import (
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/math/fixed"
+)
+
+// testCJKFont is a bitmap font with a latin and a CJK glyph,
+// standing in for the bundled CJK font
+const testCJKFont = `STARTFONT 2.1
+FONT -test-cjk-medium-r-normal--8-80-75-75-c-80-iso10646-1
+SIZE 8 75 75
+FONTBOUNDINGBOX 8 8 0 -1
+PIXEL_SIZE 8
+FONT_ASCENT 7
+FONT_DESCENT 1
+CHARS 2
+STARTCHAR A
+ENCODING 65
+DWIDTH 5 0
+BBX 4 2 0 0
+BITMAP
+60
+90
+ENDCHAR
+STARTCHAR uni4E2D
+ENCODING 20013
+DWIDTH 8 0
+BBX 8 2 0 0
+BITMAP
+FF
+18
+ENDCHAR
+ENDFONT
+`
+
+func TestDefaultFamilyStyles(t *testing.T) {
+	cjk, err := ParseBDF([]byte(testCJKFont))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fonts := NewFontRegistry()
+	fonts.Register(FontFamilyDefault, FontWeightNormal, FontStyleNormal, cjk)
+	goBold, _ := ParseTrueType(gobold.TTF)
+
+	// Glyphs the go font has are set in it, the rest in the default font
+	face := fonts.Face("", FontWeightBold, FontStyleNormal, 8, 72)
+	boldFace := goBold.Face(8, 72)
+	if got, _ := face.GlyphAdvance('A'); got != advance(boldFace, 'A') {
+		t.Errorf("Expected A in go bold, %v wide, got %v", advance(boldFace, 'A'), got)
+	}
+	cjkFace := cjk.Face(8, 72)
+	if got, _ := face.GlyphAdvance('中'); got != advance(cjkFace, '中') {
+		t.Errorf("Expected 中 in the default font, %v wide, got %v", advance(cjkFace, '中'), got)
+	}
+	if _, mask, _, _, ok := face.Glyph(fixed.P(0, 7), '中'); !ok || mask == nil {
+		t.Error("Expected a glyph for 中")
+	}
+	if face.Metrics() != cjkFace.Metrics() {
+		t.Error("Expected the default font's metrics")
+	}
+
+	// Output formats set whole runs in one font
+	tests := []struct {
+		text   string
+		weight int
+		style  string
+		// inDefault is whether the text is set in the default font
+		inDefault bool
+	}{
+		{"Bold", FontWeightBold, FontStyleNormal, false},
+		{"Bold 中", FontWeightBold, FontStyleNormal, true},
+		{"中", FontWeightNormal, FontStyleItalic, true},
+		{"Regular", FontWeightNormal, FontStyleNormal, true},
+	}
+	for _, test := range tests {
+		got := fonts.sourceFor(FontFamilyDefault, test.weight, test.style, test.text)
+		if _, inDefault := got.(*BitmapFont); inDefault != test.inDefault {
+			t.Errorf("Expected %q at %d %s to be in the default font: %v", test.text, test.weight, test.style, test.inDefault)
+		}
+	}
+
+	// Named families aren't mixed with the go fonts
+	fonts.Register("cjk", FontWeightNormal, FontStyleNormal, cjk)
+	if got, _ := fonts.Face("cjk", FontWeightBold, FontStyleNormal, 8, 72).GlyphAdvance('A'); got != advance(cjkFace, 'A') {
+		t.Errorf("Expected A in the cjk family, got %v wide", got)
+	}
+}
+
+func advance(face font.Face, r rune) fixed.Int26_6 {
+	a, _ := face.GlyphAdvance(r)
+	return a
+}
@@ -1,6 +1,6 @@
 module github.com/woosteln/goepd
 
-go 1.14
+go 1.16
 
 require (
 	github.com/GeertJohan/go.rice v1.0.0
@@ -8,6 +8,7 @@ require (
 	github.com/esimov/dithergo v0.0.0-20190411040508-1672f44e9674
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/jinzhu/copier v0.0.0-20190924061706-b57f9002281a
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/kjk/flex v0.0.0-20171203210503-ed34d6b6a425
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/gommon v0.3.0 // indirect
@@ -15,5 +16,6 @@ require (
 	github.com/sirupsen/logrus v1.5.0
 	golang.org/x/crypto v0.0.0-20200414173820-0848c9571904 // indirect
 	golang.org/x/image v0.0.0-20200119044424-58c23975cae1
+	gopkg.in/yaml.v3 v3.0.1
 	periph.io/x/periph v3.6.2+incompatible
 )
@@ -0,0 +1,389 @@
+package epd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The golden tests render content through a display's RenderFrame,
+// as it would be sent to a 4.2" panel, and compare the black and
+// red planes pixel for pixel with the PNGs in testdata/golden.
+// After an intended change to rendering, look over the diffs, then
+// run the tests with -update to write new goldens:
+//
+//	go test -run Golden -update
+//
+// Failed frames are written to testdata/failed, as the frame and a
+// diff with the pixels that changed in blue over the golden.
+
+var update = flag.Bool("update", false, "Write golden images instead of comparing against them")
+
+const (
+	goldenDir = "testdata/golden"
+	failedDir = "testdata/failed"
+)
+
+// framePalette are the colours a frame's pixels unpack to. A
+// pixel can be set in both planes, which is dark red.
+var framePalette = color.Palette{
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0xff, 0x00, 0x00, 0xff},
+	color.RGBA{0x80, 0x00, 0x00, 0xff},
+}
+
+type goldenCase struct {
+	name        string
+	orientation Orientation
+	tpl         RenderTemplate
+	content     RenderContent
+}
+
+func TestGoldenTemplates(t *testing.T) {
+	templates := map[string]RenderTemplate{
+		"landscape": TplDefaulltLandscape,
+		"portrait":  TplDefaultPortrait,
+	}
+	for name, tpl := range templates {
+		for _, orientation := range []Orientation{Landscape, Portrait} {
+			testGolden(t, goldenCase{
+				name:        fmt.Sprintf("template_%s_%s", name, orientationName(orientation)),
+				orientation: orientation,
+				tpl:         tpl,
+				content:     goldenContent(),
+			})
+		}
+	}
+	// The auto template picks one of the above, so it's shown with
+	// only a body, leaving the title, footer and image empty
+	for _, orientation := range []Orientation{Landscape, Portrait} {
+		testGolden(t, goldenCase{
+			name:        fmt.Sprintf("template_empty_%s", orientationName(orientation)),
+			orientation: orientation,
+			tpl:         TplDefaultAuto,
+			content:     RenderContent{"body": benchParagraph},
+		})
+	}
+}
+
+func TestGoldenWrapping(t *testing.T) {
+	paragraphs := benchParagraph + "\n\n" + benchParagraph
+	cases := []goldenCase{
+		{
+			name: "wrap_align",
+			tpl: `{"flexDirection": "row", "children": [
+				{"id": "left", "type": "text", "flexGrow": 1, "flexBasis": 0, "padding": 4},
+				{"id": "center", "type": "text", "textAlign": "center", "flexGrow": 1, "flexBasis": 0, "padding": 4},
+				{"id": "right", "type": "text", "textAlign": "right", "flexGrow": 1, "flexBasis": 0, "padding": 4},
+				{"id": "justify", "type": "text", "textAlign": "justify", "flexGrow": 1, "flexBasis": 0, "padding": 4}
+			]}`,
+			content: RenderContent{"left": paragraphs, "center": paragraphs, "right": paragraphs, "justify": paragraphs},
+		},
+		{
+			name: "wrap_overflow",
+			tpl: `{"flexDirection": "column", "children": [
+				{"id": "ellipsis", "type": "text", "height": 60, "textOverflow": "ellipsis", "margin": 4},
+				{"id": "clip", "type": "text", "height": 60, "textOverflow": "clip", "margin": 4},
+				{"id": "shrink", "type": "text", "height": 60, "fit": "shrink", "margin": 4},
+				{"id": "long", "type": "text", "width": 80, "letterSpacing": 1, "margin": 4}
+			]}`,
+			content: RenderContent{
+				"ellipsis": paragraphs,
+				"clip":     paragraphs,
+				"shrink":   paragraphs,
+				"long":     "Supercalifragilisticexpialidocious",
+			},
+		},
+		{
+			name:        "wrap_markdown",
+			orientation: Portrait,
+			tpl:         `{"children": [{"id": "notes", "type": "text", "format": "markdown", "flexGrow": 1, "padding": 8}]}`,
+			content: RenderContent{
+				"notes": "# Notes\n\nSome **bold**, *italic* and `code` text that wraps onto the next line.\n\n---\n\n" +
+					"- " + benchParagraph[:60] + "\n- " + benchParagraph[60:140] + "\n\n1. one\n2. two",
+			},
+		},
+	}
+	for _, c := range cases {
+		testGolden(t, c)
+	}
+}
+
+func TestGoldenImages(t *testing.T) {
+	img := goldenImage(160, 90)
+	for _, orientation := range []Orientation{Landscape, Portrait} {
+		testGolden(t, goldenCase{
+			name:        "images_" + orientationName(orientation),
+			orientation: orientation,
+			tpl: `{"flexDirection": "row", "flexWrap": "wrap", "children": [
+				{"id": "contain", "type": "img", "width": 100, "height": 100, "borderWidth": 1},
+				{"id": "cover", "type": "img", "objectFit": "cover", "width": 100, "height": 100, "borderWidth": 1},
+				{"id": "fill", "type": "img", "objectFit": "fill", "width": 100, "height": 100, "borderWidth": 1},
+				{"id": "none", "type": "img", "objectFit": "none", "objectPosition": "right bottom", "width": 100, "height": 100, "borderWidth": 1},
+				{"id": "coverLeft", "type": "img", "objectFit": "cover", "objectPosition": "left", "width": 100, "height": 100, "borderWidth": 1},
+				{"id": "threshold", "type": "img", "threshold": 50, "width": 100, "height": 100, "borderWidth": 1}
+			]}`,
+			content: RenderContent{
+				"contain":   img,
+				"cover":     img,
+				"fill":      img,
+				"none":      img,
+				"coverLeft": img,
+				"threshold": img,
+			},
+		})
+	}
+}
+
+func TestGoldenColors(t *testing.T) {
+	testGolden(t, goldenCase{
+		name: "colors",
+		tpl: `{"flexDirection": "column", "padding": 8, "children": [
+			{"id": "title", "type": "text", "fontSize": 2, "color": "red", "borderWidth": 2, "borderColor": "red", "borderRadius": 8, "padding": 4},
+			{"id": "banner", "type": "text", "backgroundColor": "red", "color": "white", "padding": 4, "margin": 4},
+			{"id": "inverted", "type": "text", "invert": true, "padding": 4},
+			{"id": "gray", "type": "text", "backgroundColor": "#b0b0b0", "padding": 4, "margin": 4},
+			{"id": "orange", "type": "text", "backgroundColor": "#ff8000", "padding": 4}
+		]}`,
+		content: RenderContent{
+			"title":    "Red title",
+			"banner":   "White on red",
+			"inverted": "Inverted",
+			"gray":     "On a light gray just over the black threshold",
+			"orange":   "On orange, which is in both planes",
+		},
+	})
+}
+
+// TestFramePacking checks where pixels go in the planes. Each row
+// of the panel is packed into bytes, most significant bit first,
+// and a bit is 0 where the pixel is black or red.
+func TestFramePacking(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	fillRoundedRect(img, roundedRect{rect: img.Bounds()}, color.White)
+	img.Set(0, 0, color.Black)
+	img.Set(9, 0, color.RGBA{0xff, 0x00, 0x00, 0xff})
+	img.Set(15, 0, color.RGBA{0xff, 0x80, 0x00, 0xff})
+	img.Set(0, 1, color.Gray{180})
+	img.Set(1, 1, color.Gray{181})
+	img.Set(399, 299, color.Black)
+
+	black, red := goldenDisplay(Landscape).convertImage(img)
+	if len(black) != 400*300/8 || len(red) != 400*300/8 {
+		t.Fatalf("Expected planes of %d bytes, got %d and %d", 400*300/8, len(black), len(red))
+	}
+
+	expectBytes := func(plane string, buf []byte, want map[int]byte) {
+		for idx, b := range buf {
+			w, ok := want[idx]
+			if !ok {
+				w = 0xff
+			}
+			if b != w {
+				t.Errorf("Expected %s byte %d to be %08b, got %08b", plane, idx, w, b)
+			}
+		}
+	}
+	// Red is dark enough to be black too
+	expectBytes("black", black, map[int]byte{0: 0x7f, 1: 0xbe, 50: 0x7f, 14999: 0xfe})
+	expectBytes("red", red, map[int]byte{1: 0xbe})
+}
+
+// testGolden renders a case and compares its frame with its golden
+func testGolden(t *testing.T, c goldenCase) {
+	t.Helper()
+
+	display := goldenDisplay(c.orientation)
+	frame, err := display.RenderFrame(c.content, c.tpl)
+	if err != nil {
+		t.Errorf("%s: %s", c.name, err)
+		return
+	}
+	got := unpackFrame(frame, display.Width(), display.Height())
+
+	path := filepath.Join(goldenDir, c.name+".png")
+	if *update {
+		if err := writePNG(path, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := readPNG(path)
+	if err != nil {
+		t.Errorf("%s: %s, run with -update to create it", c.name, err)
+		return
+	}
+	diff, changed := diffImages(want, got)
+	if changed == 0 {
+		return
+	}
+
+	framePath := filepath.Join(failedDir, c.name+".png")
+	diffPath := filepath.Join(failedDir, c.name+".diff.png")
+	if err := writePNG(framePath, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePNG(diffPath, diff); err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("%s: %d pixels differ from the golden, see %s and %s", c.name, changed, framePath, diffPath)
+}
+
+func goldenDisplay(orientation Orientation) smallEpd {
+	return smallEpd{
+		epd: epd{
+			RendererOpts: RenderOpts{Renderer: goldenEngine(), Template: TplDefaultAuto},
+			width:        400,
+			height:       300,
+			orientation:  orientation,
+		},
+	}
+}
+
+var (
+	goldenEngineOnce sync.Once
+	goldenRenderer   flexRenderEngine
+)
+
+// goldenEngine renders with the go fonts only, so the goldens
+// don't depend on the fonts of the machine the tests run on
+func goldenEngine() flexRenderEngine {
+	goldenEngineOnce.Do(func() {
+		goldenRenderer = NewFlexRenderEngineWithFonts(11, 72, NewFontRegistry())
+	})
+	return goldenRenderer
+}
+
+func goldenContent() RenderContent {
+	return RenderContent{
+		"title":  "Hello World!",
+		"body":   benchParagraph,
+		"footer": "Hope you like it",
+		"img":    goldenImage(200, 120),
+	}
+}
+
+// goldenImage is a test card of gradients, stripes and a red
+// disc, so scaling and thresholding show up in the frame
+func goldenImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	cx, cy, radius := width*2/3, height/2, height/3
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var c color.RGBA
+			switch {
+			case (x-cx)*(x-cx)+(y-cy)*(y-cy) < radius*radius:
+				c = color.RGBA{0xe0, 0x10, 0x10, 0xff}
+			case y < height/4:
+				c = color.RGBA{0, 0, 0, 0xff}
+				if (x/4)%2 == 0 {
+					c = color.RGBA{0xff, 0xff, 0xff, 0xff}
+				}
+			default:
+				v := uint8(x * 255 / width)
+				c = color.RGBA{v, v, v, 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// unpackFrame turns a frame's planes back into an image
+func unpackFrame(frame Frame, width, height int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), framePalette)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			idx := y*width + x
+			mask := byte(1) << uint(7-idx%8)
+			black := frame.Black[idx/8]&mask == 0
+			red := frame.Red[idx/8]&mask == 0
+			switch {
+			case black && red:
+				img.SetColorIndex(x, y, 3)
+			case red:
+				img.SetColorIndex(x, y, 2)
+			case black:
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// diffImages returns an image of the pixels of got that differ
+// from want in blue over a faded want, and how many there are
+func diffImages(want, got image.Image) (diff *image.RGBA, changed int) {
+	bounds := want.Bounds().Union(got.Bounds())
+	diff = image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			w := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			if !p.In(want.Bounds()) || !p.In(got.Bounds()) || w != g {
+				diff.SetRGBA(x, y, color.RGBA{0x00, 0x00, 0xff, 0xff})
+				changed++
+				continue
+			}
+			diff.SetRGBA(x, y, color.RGBA{fade(w.R), fade(w.G), fade(w.B), 0xff})
+		}
+	}
+	return
+}
+
+// fade moves a colour channel three quarters of the way to white
+func fade(v uint8) uint8 {
+	return 0xff - (0xff-v)/4
+}
+
+func readPNG(path string) (img image.Image, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func orientationName(o Orientation) string {
+	if o == Portrait {
+		return "portrait"
+	}
+	return "landscape"
+}
+
+// TestMain clears out the frames of earlier failures, so the
+// ones in testdata/failed are all from this run
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if entries, err := os.ReadDir(failedDir); err == nil {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".png") {
+				os.Remove(filepath.Join(failedDir, entry.Name()))
+			}
+		}
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,69 @@
+package epd
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NodeTypeIcon is a node that draws an icon from the built in
+// icon set. Its content, or its icon if it has no content,
+// is the name of the icon.
+const NodeTypeIcon = "icon"
+
+//go:embed icons/*.svg
+var iconFiles embed.FS
+
+// IconNames lists the icons in the built in icon set
+func IconNames() (names []string) {
+	entries, _ := iconFiles.ReadDir("icons")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".svg"))
+	}
+	sort.Strings(names)
+	return
+}
+
+// IconSVG returns the svg source of an icon from the
+// built in icon set
+func IconSVG(name string) (source string, err error) {
+	data, err := iconFiles.ReadFile(path.Join("icons", strings.ToLower(name)+".svg"))
+	if err != nil {
+		return "", fmt.Errorf("Unknown icon %s", name)
+	}
+	return string(data), nil
+}
+
+// prepareSVG parses the svg of svg and icon nodes, so errors
+// are found before layout. A node with an icon is drawn as
+// that icon whatever its type.
+func (n *Node) prepareSVG() (err error) {
+	source, isString := n.Content.(string)
+	switch {
+	case n.Type == NodeTypeIcon || (n.Icon != "" && n.Content == nil):
+		name := n.Icon
+		if isString && source != "" {
+			name = source
+		}
+		if name == "" {
+			return
+		}
+		if source, err = IconSVG(name); err != nil {
+			return &ContentError{ID: n.ID, Err: err}
+		}
+	case n.Type == NodeTypeSVG:
+		if !isString || source == "" {
+			return
+		}
+	default:
+		return
+	}
+	img, err := parseSVG(source)
+	if err != nil {
+		return &ContentError{ID: n.ID, Err: err}
+	}
+	n.Content = img
+	return
+}
@@ -0,0 +1,167 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ObjectFitContain scales the image to fit inside the node,
+	// leaving space around it
+	ObjectFitContain = "contain"
+	// ObjectFitCover scales the image to cover the node,
+	// cropping what falls outside it
+	ObjectFitCover = "cover"
+	// ObjectFitFill stretches the image to the node's size
+	ObjectFitFill = "fill"
+	// ObjectFitNone draws the image at its own size, cropped
+	// to the node
+	ObjectFitNone = "none"
+)
+
+// ObjectPositionFromString maps a css style object position to
+// fractions across and down. It takes keywords (left, center,
+// right, top, bottom) or percentages, such as "top",
+// "right bottom" or "30% 20%". Anything unrecognised is centred.
+//
+// The position is both where the image sits in its node and the
+// point of the image that stays in view when it's cropped, so a
+// percentage works as a focal point.
+func ObjectPositionFromString(position string) (x, y float64) {
+	x, y = 0.5, 0.5
+	var percents []float64
+	for _, token := range strings.Fields(strings.ToLower(position)) {
+		switch token {
+		case "left":
+			x = 0
+		case "right":
+			x = 1
+		case "top":
+			y = 0
+		case "bottom":
+			y = 1
+		case "center", "centre":
+		default:
+			if p, err := strconv.ParseFloat(strings.TrimSuffix(token, "%"), 64); err == nil {
+				percents = append(percents, math.Max(0, math.Min(100, p))/100)
+			}
+		}
+	}
+	if len(percents) > 0 {
+		x = percents[0]
+	}
+	if len(percents) > 1 {
+		y = percents[1]
+	}
+	return
+}
+
+// imageSize is the size an image is scaled to in bounds for
+// an object fit
+func imageSize(fit string, imgSize, bounds image.Point) (width, height int) {
+	width, height = imgSize.X, imgSize.Y
+	if width == 0 || height == 0 {
+		return
+	}
+	scaleX := float64(bounds.X) / float64(width)
+	scaleY := float64(bounds.Y) / float64(height)
+	switch fit {
+	case ObjectFitFill:
+		return bounds.X, bounds.Y
+	case ObjectFitNone:
+		return
+	case ObjectFitCover:
+		scaleX = math.Max(scaleX, scaleY)
+	default:
+		scaleX = math.Min(scaleX, scaleY)
+	}
+	return int(math.Round(float64(width) * scaleX)), int(math.Round(float64(height) * scaleX))
+}
+
+// processImage applies a node's brightness, contrast, gamma and
+// sharpen adjustments to img
+func (n *Node) processImage(img image.Image) image.Image {
+	if n.Brightness != 0 {
+		img = imaging.AdjustBrightness(img, n.Brightness)
+	}
+	if n.Contrast != 0 {
+		img = imaging.AdjustContrast(img, n.Contrast)
+	}
+	if n.Gamma > 0 && n.Gamma != 1 {
+		img = imaging.AdjustGamma(img, n.Gamma)
+	}
+	if n.Sharpen > 0 {
+		img = imaging.Sharpen(img, n.Sharpen)
+	}
+	return img
+}
+
+// thresholdImage makes pixels black where their luminance is below
+// threshold percent, and white elsewhere
+func thresholdImage(img image.Image, threshold float64) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rectangle{Max: bounds.Size()})
+	limit := uint8(math.Min(255, threshold*255/100))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := ColorWhite
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < limit {
+				col = ColorBlack
+			}
+			out.Set(x-bounds.Min.X, y-bounds.Min.Y, col)
+		}
+	}
+	return out
+}
+
+// drawImage draws a node's image into bounds, scaled to its
+// object fit and placed at its object position, then processed
+// and dithered, or thresholded if the node sets a threshold.
+func (r flexRenderEngine) drawImage(node *Node, img image.Image, bounds image.Rectangle, dst *image.RGBA) {
+
+	if bounds.Empty() {
+		return
+	}
+
+	width, height := imageSize(node.ObjectFit, img.Bounds().Size(), bounds.Size())
+	if width <= 0 || height <= 0 {
+		return
+	}
+	posX, posY := ObjectPositionFromString(node.ObjectPosition)
+	origin := bounds.Min.Add(image.Point{
+		X: int(math.Round(float64(bounds.Dx()-width) * posX)),
+		Y: int(math.Round(float64(bounds.Dy()-height) * posY)),
+	})
+	imgBounds := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+	visible := imgBounds.Intersect(bounds)
+	if visible.Empty() {
+		return
+	}
+
+	log.Debugf("Scaling image to [%d, %d] in [%d, %d]", width, height, bounds.Dx(), bounds.Dy())
+
+	scaled := img
+	if width != img.Bounds().Dx() || height != img.Bounds().Dy() {
+		scaled = imaging.Resize(img, width, height, imaging.Lanczos)
+	}
+	// Only what's in view is processed, so crops don't
+	// dither the edge of the box
+	scaled = imaging.Crop(scaled, visible.Sub(origin).Add(scaled.Bounds().Min))
+	scaled = node.processImage(scaled)
+
+	var output image.Image
+	if node.Threshold > 0 {
+		output = thresholdImage(scaled, node.Threshold)
+	} else {
+		multiplier := 0.3 // Smaller for smaller images
+		output = atkinsonDither.Monochrome(scaled, float32(multiplier))
+	}
+	draw.Draw(dst, visible, output, output.Bounds().Min, draw.Src)
+}
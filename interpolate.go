@@ -0,0 +1,245 @@
+package epd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TemplateFuncs are the functions available to expressions in
+// templates, alongside text/template's own. Each takes the value
+// it works on last, so they can be used in pipelines like
+// `{{ .updated | date "15:04" }}`.
+//
+//	date layout t         formats a time, unix seconds or RFC3339 string
+//	number decimals n     formats a number with thousands separators
+//	round decimals n      rounds a number
+//	plural one many n     picks the singular or plural word for n
+//	truncate length s     shortens s to length characters with an ellipsis
+//	default fallback v    is fallback if v is nil or empty
+//	upper s, lower s      change case
+//	now                   the current time
+var TemplateFuncs = template.FuncMap{
+	"date":     formatDate,
+	"number":   formatNumber,
+	"round":    roundNumber,
+	"plural":   plural,
+	"truncate": truncate,
+	"default":  defaultValue,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"now":      time.Now,
+}
+
+// interpolate evaluates the expressions in the content of
+// template nodes against the render content, and removes the
+// nodes whose "if" expression is false. Only text written in
+// the template is evaluated, never the content bound to nodes.
+func interpolate(root *Node, content RenderContent) (err error) {
+	show, err := interpolateNode(root, "$", content)
+	if err == nil && !show {
+		// The root can't be removed, so it's emptied instead
+		root.Content = nil
+		root.Children = nil
+	}
+	return
+}
+
+func interpolateNode(node *Node, path string, content RenderContent) (show bool, err error) {
+
+	if node.If != "" {
+		if show, err = evalCondition(node.If, path+".if", content); err != nil || !show {
+			return
+		}
+	}
+
+	if text, ok := node.Content.(string); ok && strings.Contains(text, "{{") {
+		if node.Content, err = evalString(text, path+".content", content); err != nil {
+			return
+		}
+	}
+
+	if node.repeatKey() != "" {
+		return true, expandRepeat(node, path, content)
+	}
+
+	children := node.Children[:0]
+	for idx, child := range node.Children {
+		showChild, errr := interpolateNode(child, fmt.Sprintf("%s.children[%d]", path, idx), content)
+		if errr != nil {
+			return false, errr
+		}
+		if showChild {
+			children = append(children, child)
+		}
+	}
+	node.Children = children
+
+	return true, nil
+}
+
+// evalString evaluates text as a template against the content.
+// Missing content is an error, hide the node with an "if" if the
+// content may be missing.
+func evalString(text, path string, content RenderContent) (result string, err error) {
+	tpl, err := template.New(path).Funcs(TemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", &TemplateError{Path: path, Err: err}
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, content); err != nil {
+		return "", &TemplateError{Path: path, Err: err}
+	}
+	return buf.String(), nil
+}
+
+// evalCondition evaluates an "if" expression such as `.alert` or
+// `gt .temperature 30.0`, with or without braces. Missing content
+// is false.
+func evalCondition(expr, path string, content RenderContent) (show bool, err error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "{{") && strings.HasSuffix(expr, "}}") {
+		expr = strings.TrimSpace(expr[2 : len(expr)-2])
+	}
+	tpl, err := template.New(path).Funcs(TemplateFuncs).Option("missingkey=zero").Parse("{{ if " + expr + " }}true{{ end }}")
+	if err != nil {
+		return false, &TemplateError{Path: path, Err: err}
+	}
+	var buf bytes.Buffer
+	if errr := tpl.Execute(&buf, content); errr != nil {
+		// Such as a field of missing content
+		log.Debugf("Hiding node at %s: %s", path, errr.Error())
+		return false, nil
+	}
+	return buf.String() == "true", nil
+}
+
+// toFloat converts numbers, and strings of numbers, to float64
+func toFloat(v interface{}) (f float64, err error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int8:
+		return float64(n), nil
+	case int16:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint8:
+		return float64(n), nil
+	case uint16:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func formatDate(layout string, v interface{}) (string, error) {
+	var t time.Time
+	switch x := v.(type) {
+	case time.Time:
+		t = x
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, x); err != nil {
+			return "", err
+		}
+	default:
+		secs, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		whole, frac := math.Modf(secs)
+		t = time.Unix(int64(whole), int64(frac*1e9))
+	}
+	return t.Format(layout), nil
+}
+
+func formatNumber(decimals int, v interface{}) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	whole, frac := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, frac = s[:dot], s[dot:]
+	}
+	var grouped []byte
+	for idx := range whole {
+		if idx > 0 && (len(whole)-idx)%3 == 0 {
+			grouped = append(grouped, ',')
+		}
+		grouped = append(grouped, whole[idx])
+	}
+	sign := ""
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		sign = "-"
+	}
+	return sign + string(grouped) + frac, nil
+}
+
+func roundNumber(decimals int, v interface{}) (float64, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(f*scale) / scale, nil
+}
+
+func plural(one, many string, v interface{}) (string, error) {
+	n, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	if n == 1 {
+		return one, nil
+	}
+	return many, nil
+}
+
+func truncate(length int, s string) string {
+	chars := splitRunes(s)
+	if len(chars) <= length {
+		return s
+	}
+	if length < 1 {
+		return ""
+	}
+	return strings.TrimRight(strings.Join(chars[:length-1], ""), " ") + ellipsis
+}
+
+func defaultValue(fallback, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return fallback
+	case string:
+		if x == "" {
+			return fallback
+		}
+	}
+	return v
+}
@@ -0,0 +1,224 @@
+package epd
+
+import (
+	"unicode"
+)
+
+// breakClass is a line breaking class from Unicode's line
+// breaking algorithm (UAX #14). Only the classes needed to
+// break Latin and CJK text sensibly are distinguished, anything
+// else is treated as alphabetic.
+type breakClass int
+
+const (
+	breakAL breakClass = iota // Alphabetic and unclassified
+	breakSP                   // Space
+	breakZW                   // Zero width space
+	breakGL                   // Non-breaking glue
+	breakCM                   // Combining mark
+	breakNU                   // Numeric
+	breakID                   // Ideographic
+	breakOP                   // Opening punctuation
+	breakCL                   // Closing punctuation
+	breakEX                   // Exclamation and interrogation
+	breakIS                   // Infix numeric separator
+	breakNS                   // Nonstarter
+	breakQU                   // Quotation
+	breakHY                   // Hyphen
+	breakBA                   // Break after
+)
+
+var breakClassMap = map[rune]breakClass{
+	' ':      breakSP,
+	'\t':     breakBA,
+	'\u200B': breakZW,
+	'\u00A0': breakGL,
+	'\u2007': breakGL,
+	'\u202F': breakGL,
+	'\u2060': breakGL,
+	'\uFEFF': breakGL,
+	'-':      breakHY,
+	'\u00AD': breakBA,
+	'‐':      breakBA,
+	'‒':      breakBA,
+	'–':      breakBA,
+	'—':      breakBA,
+	'|':      breakBA,
+	'\u3000': breakBA,
+	'!':      breakEX,
+	'?':      breakEX,
+	'！':      breakEX,
+	'？':      breakEX,
+	',':      breakIS,
+	'.':      breakIS,
+	':':      breakIS,
+	';':      breakIS,
+	'"':      breakQU,
+	'\'':     breakQU,
+	'«':      breakQU,
+	'»':      breakQU,
+	'‘':      breakQU,
+	'’':      breakQU,
+	'“':      breakQU,
+	'”':      breakQU,
+	'(':      breakOP,
+	'[':      breakOP,
+	'{':      breakOP,
+	'¡':      breakOP,
+	'¿':      breakOP,
+	'〈':      breakOP,
+	'《':      breakOP,
+	'「':      breakOP,
+	'『':      breakOP,
+	'【':      breakOP,
+	'〔':      breakOP,
+	'〖':      breakOP,
+	'（':      breakOP,
+	'［':      breakOP,
+	'｛':      breakOP,
+	')':      breakCL,
+	']':      breakCL,
+	'}':      breakCL,
+	'、':      breakCL,
+	'。':      breakCL,
+	'〉':      breakCL,
+	'》':      breakCL,
+	'」':      breakCL,
+	'』':      breakCL,
+	'】':      breakCL,
+	'〕':      breakCL,
+	'〗':      breakCL,
+	'）':      breakCL,
+	'，':      breakCL,
+	'．':      breakCL,
+	'］':      breakCL,
+	'｝':      breakCL,
+	'：':      breakNS,
+	'；':      breakNS,
+	'々':      breakNS,
+	'〻':      breakNS,
+	'ゝ':      breakNS,
+	'ゞ':      breakNS,
+	'・':      breakNS,
+	'ー':      breakNS,
+	'ヽ':      breakNS,
+	'ヾ':      breakNS,
+}
+
+// smallKana can't start a line in Japanese text
+const smallKana = "ぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇺㇻㇼㇽㇾㇿ"
+
+var ideographic = []*unicode.RangeTable{
+	unicode.Han,
+	unicode.Hiragana,
+	unicode.Katakana,
+	unicode.Hangul,
+}
+
+func lineBreakClass(r rune) breakClass {
+	if class, ok := breakClassMap[r]; ok {
+		return class
+	}
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return breakCM
+	case unicode.IsDigit(r):
+		return breakNU
+	case containsRune(smallKana, r):
+		return breakNS
+	case unicode.In(r, ideographic...),
+		r >= 0xFF01 && r <= 0xFF60,   // Fullwidth forms
+		r >= 0x1F300 && r <= 0x1FAFF: // Pictographs and emoji
+		return breakID
+	}
+	return breakAL
+}
+
+func containsRune(s string, r rune) bool {
+	for _, c := range s {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// canBreak reports whether a line may break between a character
+// of class before and one of class after, with or without spaces
+// between them.
+func canBreak(before breakClass, spaces bool, after breakClass) bool {
+	switch {
+	case after == breakSP, after == breakCM:
+		return false
+	case before == breakZW:
+		return true
+	case after == breakCL, after == breakEX, after == breakIS:
+		return false
+	case before == breakOP:
+		return false
+	case spaces:
+		return true
+	case before == breakGL, after == breakGL:
+		return false
+	case before == breakQU, after == breakQU:
+		return false
+	case after == breakNS, after == breakBA, after == breakHY:
+		return false
+	case before == breakHY:
+		return after != breakNU
+	case before == breakBA:
+		return true
+	case before == breakID, after == breakID:
+		return true
+	}
+	return false
+}
+
+// lineSegments splits a paragraph at each of its line break
+// opportunities. Each segment keeps any trailing spaces, so the
+// segments join back into the paragraph.
+func lineSegments(para string) (segments []string) {
+	start := 0
+	prev := breakClass(-1)
+	spaces := false
+	for idx, r := range para {
+		class := lineBreakClass(r)
+		if prev >= 0 && canBreak(prev, spaces, class) {
+			segments = append(segments, para[start:idx])
+			start = idx
+		}
+		switch class {
+		case breakSP:
+			spaces = true
+		case breakCM:
+			// Combining marks take the class of the character
+			// they combine with
+			if prev < 0 {
+				prev = breakAL
+			}
+		default:
+			prev = class
+			spaces = false
+		}
+	}
+	if start < len(para) {
+		segments = append(segments, para[start:])
+	}
+	return
+}
+
+// splitRunes splits a string into its characters, keeping
+// combining marks with the character they combine with.
+func splitRunes(s string) (chars []string) {
+	start := 0
+	for idx, r := range s {
+		if idx > start && lineBreakClass(r) != breakCM {
+			chars = append(chars, s[start:idx])
+			start = idx
+		}
+	}
+	if start < len(s) {
+		chars = append(chars, s[start:])
+	}
+	return
+}
@@ -0,0 +1,471 @@
+package epd
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"regexp"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// TextFormatPlain draws text as it is
+	TextFormatPlain = "plain"
+	// TextFormatMarkdown formats text written in markdown, with
+	// headings, bold, italic, inline code, lists and rules
+	TextFormatMarkdown = "markdown"
+)
+
+// headingScales are the sizes of headings, by level,
+// as a multiple of the text's size
+var headingScales = [...]float64{2, 1.5, 1.25, 1.1, 1, 1}
+
+const bullet = "•"
+
+type mdBlockKind int
+
+const (
+	mdParagraph mdBlockKind = iota
+	mdHeading
+	mdListItem
+	mdCode
+	mdRule
+)
+
+// mdSpan is a run of text in a single style
+type mdSpan struct {
+	text   string
+	bold   bool
+	italic bool
+	code   bool
+}
+
+// mdBlock is a heading, paragraph, list item, line of code or rule
+type mdBlock struct {
+	kind mdBlockKind
+	// level is the level of a heading, or how deeply
+	// a list item is nested
+	level  int
+	marker string
+	text   string
+}
+
+var (
+	mdHeadingPattern  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRulePattern     = regexp.MustCompile(`^\s*(([-*_])\s*){3,}$`)
+	mdListItemPattern = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+)
+
+// parseMarkdown splits markdown into its blocks. Lines of a
+// paragraph or list item are joined, as markdown does.
+func parseMarkdown(text string) (blocks []mdBlock) {
+
+	code := false
+	// open is true while the last block can take more lines
+	open := false
+
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			code = !code
+			open = false
+			continue
+		}
+		if code {
+			blocks = append(blocks, mdBlock{kind: mdCode, text: strings.TrimRight(line, " \t")})
+			continue
+		}
+
+		if trimmed == "" {
+			open = false
+			continue
+		}
+
+		if m := mdHeadingPattern.FindStringSubmatch(trimmed); m != nil {
+			blocks = append(blocks, mdBlock{kind: mdHeading, level: len(m[1]), text: m[2]})
+			open = false
+			continue
+		}
+		if mdRulePattern.MatchString(line) {
+			blocks = append(blocks, mdBlock{kind: mdRule})
+			open = false
+			continue
+		}
+		if m := mdListItemPattern.FindStringSubmatch(line); m != nil {
+			marker := bullet
+			if number := strings.TrimRight(m[2], ".)"); number != m[2] {
+				marker = number + "."
+			}
+			indent := strings.Replace(m[1], "\t", "  ", -1)
+			blocks = append(blocks, mdBlock{kind: mdListItem, level: len(indent) / 2, marker: marker, text: m[3]})
+			open = true
+			continue
+		}
+
+		if open {
+			last := &blocks[len(blocks)-1]
+			last.text += " " + trimmed
+			continue
+		}
+		blocks = append(blocks, mdBlock{kind: mdParagraph, text: trimmed})
+		open = true
+	}
+
+	return
+}
+
+// parseInline splits text into spans of bold, italic and code.
+// Links are replaced by their text, and a backslash escapes
+// the character after it.
+func parseInline(text string) (spans []mdSpan) {
+
+	var current mdSpan
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			current.text = buf.String()
+			spans = append(spans, current)
+			buf.Reset()
+		}
+	}
+	isSpace := func(idx int) bool {
+		return idx < 0 || idx >= len(text) || text[idx] == ' ' || text[idx] == '\t'
+	}
+	isWord := func(idx int) bool {
+		if idx < 0 || idx >= len(text) {
+			return false
+		}
+		c := text[idx]
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_[]()#+-.!", text[i+1]) >= 0:
+			buf.WriteByte(text[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				flush()
+				code := current
+				code.code, code.text = true, text[i+1:i+1+end]
+				spans = append(spans, code)
+				i += end + 2
+				continue
+			}
+
+		case c == '[':
+			if close := strings.Index(text[i:], "]("); close > 0 {
+				if end := strings.IndexByte(text[i+close:], ')'); end > 0 {
+					buf.WriteString(text[i+1 : i+close])
+					i += close + end + 1
+					continue
+				}
+			}
+
+		case c == '*' || c == '_':
+			width := 1
+			if i+1 < len(text) && text[i+1] == c {
+				width = 2
+			}
+			on := current.italic
+			if width == 2 {
+				on = current.bold
+			}
+			// Delimiters open before text and close after it, and
+			// underscores inside words, as in snake_case, are text
+			opens := !on && !isSpace(i+width) && (c == '*' || !isWord(i-1))
+			closes := on && !isSpace(i-1) && (c == '*' || !isWord(i+width))
+			if opens || closes {
+				flush()
+				if width == 2 {
+					current.bold = !on
+				} else {
+					current.italic = !on
+				}
+				i += width
+				continue
+			}
+		}
+		buf.WriteByte(c)
+		i++
+	}
+	flush()
+	return
+}
+
+// mdRun is text drawn in one face on a line
+type mdRun struct {
+	text string
+	face textFace
+	// style is the span style the face is for
+	style mdSpan
+	x     float64
+	width float64
+}
+
+// mdLine is a laid out line of markdown
+type mdLine struct {
+	runs []mdRun
+	// width is the right edge of the line's text
+	width  float64
+	top    float64
+	height float64
+	ascent float64
+	rule   bool
+}
+
+// mdLayout is markdown laid out in a box
+type mdLayout struct {
+	lines  []mdLine
+	width  float64
+	height float64
+	// overflow is true when there were more lines
+	// than could fit in the box
+	overflow bool
+}
+
+// layoutMarkdown lays markdown out in a box of width by height
+// pixels, in the style for the body text. A height of +Inf
+// doesn't limit the lines.
+func (r flexRenderEngine) layoutMarkdown(text string, style TextStyle, width, height float64) (layout mdLayout) {
+
+	lineScale := style.LineHeight
+	if lineScale <= 0 {
+		lineScale = 1
+	}
+	baseFace := r.textFace(style)
+	baseHeight := Int26_6ToFloat64(baseFace.Metrics().Height) * lineScale
+	scale := style.FontScale
+	if scale <= 0 && r.fontSize > 0 {
+		// Faces of size 0 are 12 points
+		scale = 12 / r.fontSize
+	}
+
+	y := 0.0
+	var prev *mdBlock
+	blocks := parseMarkdown(text)
+	for idx := range blocks {
+		block := blocks[idx]
+
+		// Blocks are spaced apart by half a line, except for
+		// the items of a list and the lines of code
+		if prev != nil && !(prev.kind == block.kind && (block.kind == mdListItem || block.kind == mdCode)) {
+			y += baseHeight / 2
+		}
+		prev = &blocks[idx]
+
+		if block.kind == mdRule {
+			layout.lines = append(layout.lines, mdLine{top: y, height: baseHeight, rule: true})
+			y += baseHeight
+			continue
+		}
+
+		blockStyle := style
+		if block.kind == mdHeading {
+			blockStyle.FontScale = scale * headingScales[block.level-1]
+			blockStyle.FontWeight = FontWeightBold
+		}
+		faces := map[mdSpan]textFace{}
+		faceFor := func(span mdSpan) textFace {
+			key := mdSpan{bold: span.bold, italic: span.italic, code: span.code || block.kind == mdCode}
+			if face, ok := faces[key]; ok {
+				return face
+			}
+			spanStyle := blockStyle
+			if key.bold {
+				spanStyle.FontWeight = FontWeightBold
+			}
+			if key.italic {
+				spanStyle.FontStyle = FontStyleItalic
+			}
+			if key.code {
+				spanStyle.FontFamily = FontFamilyMono
+			}
+			faces[key] = r.textFace(spanStyle)
+			return faces[key]
+		}
+
+		// List items hang their text off their marker
+		indent, textX := 0.0, 0.0
+		var marker *mdRun
+		if block.kind == mdListItem {
+			indent = float64(block.level) * baseFace.measure("0000")
+			markerWidth := math.Max(baseFace.measure(block.marker), baseFace.measure("0."))
+			marker = &mdRun{text: block.marker, face: baseFace, x: indent, width: baseFace.measure(block.marker)}
+			textX = indent + markerWidth + baseFace.measure(" ")
+		}
+
+		var spans []mdSpan
+		if block.kind == mdCode {
+			spans = []mdSpan{{text: block.text, code: true}}
+		} else {
+			spans = parseInline(block.text)
+		}
+
+		lines := wrapSpans(spans, faceFor, textX, width)
+		if len(lines) == 0 {
+			// Empty lines of code still take up a line
+			lines = append(lines, mdLine{})
+		}
+		if marker != nil {
+			lines[0].runs = append([]mdRun{*marker}, lines[0].runs...)
+		}
+		for _, line := range lines {
+			fontHeight, ascent := 0.0, 0.0
+			for _, run := range line.runs {
+				metrics := run.face.Metrics()
+				fontHeight = math.Max(fontHeight, Int26_6ToFloat64(metrics.Height))
+				ascent = math.Max(ascent, Int26_6ToFloat64(metrics.Ascent))
+			}
+			if len(line.runs) == 0 {
+				metrics := faceFor(mdSpan{}).Metrics()
+				fontHeight, ascent = Int26_6ToFloat64(metrics.Height), Int26_6ToFloat64(metrics.Ascent)
+			}
+			line.top = y
+			line.height = fontHeight * lineScale
+			// Extra line height is split above and below the line
+			line.ascent = ascent + (line.height-fontHeight)/2
+			y += line.height
+			layout.lines = append(layout.lines, line)
+		}
+	}
+
+	truncated := false
+	if style.MaxLines > 0 && style.MaxLines < len(layout.lines) {
+		layout.lines = layout.lines[:style.MaxLines]
+		layout.overflow = true
+		truncated = true
+	}
+	fit := len(layout.lines)
+	for fit > 0 && layout.lines[fit-1].top+layout.lines[fit-1].height > height {
+		fit--
+	}
+	if fit < len(layout.lines) {
+		layout.overflow = true
+		if style.Overflow == TextOverflowClip || style.Overflow == TextOverflowEllipsis {
+			layout.lines = layout.lines[:max(fit, 1)]
+			truncated = true
+		}
+	}
+	if truncated && style.Overflow == TextOverflowEllipsis {
+		last := &layout.lines[len(layout.lines)-1]
+		if count := len(last.runs); count > 0 {
+			run := &last.runs[count-1]
+			run.text = ellipsize(run.face, run.text, width-run.x)
+			run.width = run.face.measure(run.text)
+			last.width = run.x + run.width
+		}
+	}
+
+	for _, line := range layout.lines {
+		layout.width = math.Max(layout.width, line.width)
+		layout.height = line.top + line.height
+	}
+	return
+}
+
+// wrapSpans lays spans out in lines starting at x, breaking
+// them at line break opportunities to fit within width
+func wrapSpans(spans []mdSpan, faceFor func(mdSpan) textFace, x, width float64) (lines []mdLine) {
+
+	var line mdLine
+	available := width - x
+	// cursor is how far along the line the text reaches
+	cursor := 0.0
+
+	endLine := func() {
+		if count := len(line.runs); count > 0 {
+			last := &line.runs[count-1]
+			last.text = strings.TrimRight(last.text, " ")
+			last.width = last.face.measure(last.text)
+			line.width = last.x + last.width
+		}
+		lines = append(lines, line)
+		line = mdLine{}
+		cursor = 0
+	}
+	add := func(style mdSpan, face textFace, text string) {
+		if count := len(line.runs); count > 0 && line.runs[count-1].style == style {
+			last := &line.runs[count-1]
+			last.text += text
+			last.width = face.measure(last.text)
+		} else {
+			line.runs = append(line.runs, mdRun{text: text, face: face, style: style, x: x + cursor, width: face.measure(text)})
+		}
+		last := line.runs[len(line.runs)-1]
+		cursor = last.x - x + last.width
+	}
+
+	for _, span := range spans {
+		face := faceFor(span)
+		style := span
+		style.text = ""
+		for _, segment := range lineSegments(span.text) {
+			if len(line.runs) > 0 && cursor+face.measure(strings.TrimRight(segment, " ")) > available {
+				endLine()
+			}
+			if len(line.runs) == 0 && len(lines) > 0 {
+				// Wrapped lines don't start with a space
+				segment = strings.TrimLeft(segment, " ")
+				if segment == "" {
+					continue
+				}
+			}
+			// A single segment wider than the line has to be
+			// broken wherever it can be
+			for cursor+face.measure(strings.TrimRight(segment, " ")) > available {
+				head, tail := breakSegment(face, segment, available-cursor)
+				if tail == "" {
+					break
+				}
+				add(style, face, head)
+				endLine()
+				segment = tail
+			}
+			add(style, face, segment)
+		}
+	}
+	if len(line.runs) > 0 {
+		endLine()
+	}
+	return
+}
+
+// drawMarkdown draws laid out markdown into bounds on dst
+func drawMarkdown(layout mdLayout, style TextStyle, src image.Image, bounds image.Rectangle, dst *image.RGBA) {
+
+	if style.Overflow == TextOverflowClip || style.Overflow == TextOverflowEllipsis {
+		dst = dst.SubImage(bounds).(*image.RGBA)
+	}
+
+	top := alignedTop(style, bounds, layout.height)
+
+	for _, line := range layout.lines {
+		if line.rule {
+			y := int(math.Round(top + line.top + line.height/2))
+			draw.Draw(dst, image.Rect(bounds.Min.X, y, bounds.Max.X, y+1), src, image.ZP, draw.Src)
+			continue
+		}
+		x := alignedLeft(style, bounds, line.width)
+		for _, run := range line.runs {
+			drawer := &font.Drawer{
+				Dst:  dst,
+				Src:  src,
+				Face: run.face.Face,
+				Dot: fixed.Point26_6{
+					X: floatToFixed(x + run.x),
+					Y: floatToFixed(top + line.top + line.ascent),
+				},
+			}
+			drawSpaced(drawer, run.face, run.text, 0, false)
+		}
+	}
+}
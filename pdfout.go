@@ -0,0 +1,148 @@
+package epd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// pdfCanvas writes a single page PDF with a point for each pixel
+type pdfCanvas struct {
+	pdf   *gofpdf.Fpdf
+	fonts *FontRegistry
+	// fallback has the go fonts, for text in fonts that can't be embedded
+	fallback *FontRegistry
+	// embedded are the pdf names of embedded fonts, by font file
+	embedded map[*byte]string
+	images   int
+}
+
+func newPDFCanvas(fonts *FontRegistry, width, height int) *pdfCanvas {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "pt",
+		Size:    gofpdf.SizeType{Wd: float64(width), Ht: float64(height)},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetCellMargin(0)
+	pdf.AddPage()
+	return &pdfCanvas{
+		pdf:      pdf,
+		fonts:    fonts,
+		embedded: make(map[*byte]string),
+	}
+}
+
+func (c *pdfCanvas) writeTo(w io.Writer) error {
+	return c.pdf.Output(w)
+}
+
+func (c *pdfCanvas) rect(box roundedRect, col color.Color, width int) {
+	x, y := float64(box.rect.Min.X), float64(box.rect.Min.Y)
+	w, h := float64(box.rect.Dx()), float64(box.rect.Dy())
+	radius := box.radius
+	r, g, b := pdfColor(col)
+	style := "F"
+	if width > 0 {
+		// Strokes are centred on their path
+		inset := float64(width) / 2
+		x, y, w, h = x+inset, y+inset, w-2*inset, h-2*inset
+		radius -= inset
+		c.pdf.SetDrawColor(r, g, b)
+		c.pdf.SetLineWidth(float64(width))
+		style = "D"
+	} else {
+		c.pdf.SetFillColor(r, g, b)
+	}
+	if radius > 0 {
+		c.pdf.RoundedRect(x, y, w, h, radius, "1234", style)
+	} else {
+		c.pdf.Rect(x, y, w, h, style)
+	}
+}
+
+func (c *pdfCanvas) text(face textFace, col color.Color, x, y float64, text string) {
+	if text == "" {
+		return
+	}
+	c.pdf.SetFont(c.font(face, text), "", 0)
+	c.pdf.SetFontUnitSize(face.size)
+	c.pdf.SetTextColor(pdfColor(col))
+	if face.letterSpacing == 0 {
+		c.pdf.Text(x, y, text)
+		return
+	}
+	// Each character is placed where it would be drawn,
+	// as letter spacing can't be set
+	prefix := ""
+	for _, char := range splitRunes(text) {
+		offset := face.measure(prefix)
+		if prefix != "" {
+			offset += face.letterSpacing
+		}
+		c.pdf.Text(x+offset, y, char)
+		prefix += char
+	}
+}
+
+func (c *pdfCanvas) image(img image.Image, bounds image.Rectangle) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		c.pdf.SetError(err)
+		return
+	}
+	c.images++
+	name := fmt.Sprintf("image%d", c.images)
+	options := gofpdf.ImageOptions{ImageType: "PNG"}
+	c.pdf.RegisterImageOptionsReader(name, options, &data)
+	c.pdf.ImageOptions(name, float64(bounds.Min.X), float64(bounds.Min.Y), float64(bounds.Dx()), float64(bounds.Dy()), false, options, 0, "")
+}
+
+func (c *pdfCanvas) invert(box roundedRect) {
+	c.pdf.SetAlpha(1, "Difference")
+	c.rect(box, color.White, 0)
+	c.pdf.SetAlpha(1, "Normal")
+}
+
+func (c *pdfCanvas) clip(bounds image.Rectangle) {
+	c.pdf.ClipRect(float64(bounds.Min.X), float64(bounds.Min.Y), float64(bounds.Dx()), float64(bounds.Dy()), false)
+}
+
+func (c *pdfCanvas) unclip() {
+	c.pdf.ClipEnd()
+}
+
+// font returns the pdf name of the font of text in a face,
+// embedding it the first time it's used. Fonts that can't be embedded are set in
+// the go font closest to them.
+func (c *pdfCanvas) font(face textFace, text string) string {
+	data, ok := embeddable(c.fonts.sourceFor(face.family, face.weight, face.style, text))
+	if !ok {
+		if c.fallback == nil {
+			c.fallback = NewFontRegistry()
+		}
+		family := FontFamilyGo
+		if strings.EqualFold(face.family, FontFamilyMono) {
+			family = FontFamilyMono
+		}
+		data, _ = embeddable(c.fallback.Source(family, face.weight, face.style))
+	}
+	name, ok := c.embedded[&data[0]]
+	if !ok {
+		name = fmt.Sprintf("font%d", len(c.embedded)+1)
+		c.embedded[&data[0]] = name
+		c.pdf.AddUTF8FontFromBytes(name, "", data)
+	}
+	return name
+}
+
+func pdfColor(c color.Color) (r, g, b int) {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return int(rgba.R), int(rgba.G), int(rgba.B)
+}
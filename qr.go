@@ -0,0 +1,545 @@
+package epd
+
+import (
+	"errors"
+	"strings"
+)
+
+// QRLevel is the error correction level of a QR code. Higher
+// levels survive more damage but hold less data.
+type QRLevel int
+
+const (
+	QRLevelL QRLevel = iota // Recovers 7% of the code
+	QRLevelM                // Recovers 15% of the code
+	QRLevelQ                // Recovers 25% of the code
+	QRLevelH                // Recovers 30% of the code
+)
+
+var QRLevelMap = map[string]QRLevel{
+	"L": QRLevelL,
+	"M": QRLevelM,
+	"Q": QRLevelQ,
+	"H": QRLevelH,
+}
+
+// QRLevelFromString maps L, M, Q or H to a level,
+// defaulting to M
+func QRLevelFromString(level string) QRLevel {
+	if l, ok := QRLevelMap[strings.ToUpper(level)]; ok {
+		return l
+	}
+	return QRLevelM
+}
+
+// ErrQRTooLong is returned when text won't fit in the largest
+// QR code at the error correction level
+var ErrQRTooLong = errors.New("Text too long for a QR code")
+
+// QRCode is an encoded QR code
+type QRCode struct {
+	// Size is the number of modules along each side,
+	// not including the quiet zone
+	Size    int
+	modules [][]bool
+	// function marks the modules of the finder, timing and
+	// alignment patterns and format info, which aren't masked
+	function [][]bool
+}
+
+// Black reports whether the module at x, y is dark
+func (q *QRCode) Black(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// Error correction codewords per block and number of blocks, by
+// level and version, from the QR code specification
+var qrECCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrECBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrFormatLevel is each level's bits in the format info
+var qrFormatLevel = [4]int{1, 0, 3, 2}
+
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+type qrMode struct {
+	indicator  int
+	countBits  [3]int // for versions 1-9, 10-26 and 27-40
+	dataBitLen func(n int) int
+}
+
+var (
+	qrNumeric = qrMode{0x1, [3]int{10, 12, 14}, func(n int) int {
+		return n/3*10 + []int{0, 4, 7}[n%3]
+	}}
+	qrAlpha = qrMode{0x2, [3]int{9, 11, 13}, func(n int) int {
+		return n/2*11 + n%2*6
+	}}
+	qrByte = qrMode{0x4, [3]int{8, 16, 16}, func(n int) int {
+		return n * 8
+	}}
+)
+
+func (m qrMode) countBitsFor(version int) int {
+	switch {
+	case version <= 9:
+		return m.countBits[0]
+	case version <= 26:
+		return m.countBits[1]
+	}
+	return m.countBits[2]
+}
+
+// qrBits is a growable bit string
+type qrBits []bool
+
+func (b *qrBits) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+// EncodeQR encodes text as the smallest QR code that holds it at
+// the error correction level. Digits and upper case text are
+// encoded compactly, anything else as UTF-8 bytes.
+func EncodeQR(text string, level QRLevel) (code *QRCode, err error) {
+
+	mode := qrByte
+	switch {
+	case text != "" && strings.Trim(text, "0123456789") == "":
+		mode = qrNumeric
+	case text != "" && strings.Trim(text, qrAlphanumeric) == "":
+		mode = qrAlpha
+	}
+	count := len(text)
+
+	version := 1
+	for ; version <= 40; version++ {
+		capacity := qrDataCodewords(version, level) * 8
+		if 4+mode.countBitsFor(version)+mode.dataBitLen(count) <= capacity {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, ErrQRTooLong
+	}
+
+	var bits qrBits
+	bits.append(mode.indicator, 4)
+	bits.append(count, mode.countBitsFor(version))
+	switch mode.indicator {
+	case qrNumeric.indicator:
+		for i := 0; i < count; i += 3 {
+			group := text[i:min(i+3, count)]
+			n := 0
+			for _, c := range group {
+				n = n*10 + int(c-'0')
+			}
+			bits.append(n, len(group)*3+1)
+		}
+	case qrAlpha.indicator:
+		for i := 0; i < count; i += 2 {
+			n := strings.IndexByte(qrAlphanumeric, text[i])
+			if i+1 < count {
+				bits.append(n*45+strings.IndexByte(qrAlphanumeric, text[i+1]), 11)
+			} else {
+				bits.append(n, 6)
+			}
+		}
+	default:
+		for i := 0; i < count; i++ {
+			bits.append(int(text[i]), 8)
+		}
+	}
+
+	// Terminate, pad to a byte, then fill with the pad bytes
+	capacity := qrDataCodewords(version, level) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	code = newQRCode(version)
+	code.drawCodewords(qrAddECC(data, version, level))
+
+	// Use the mask that makes the code easiest to scan
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormat(level, mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormat(level, best)
+
+	return
+}
+
+// qrRawModules is the number of modules of a version
+// that can hold data and error correction
+func qrRawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func qrDataCodewords(version int, level QRLevel) int {
+	return qrRawModules(version)/8 - qrECCodewordsPerBlock[level][version]*qrECBlocks[level][version]
+}
+
+// qrAddECC splits data into blocks, adds Reed-Solomon error
+// correction to each, and interleaves them
+func qrAddECC(data []byte, version int, level QRLevel) (result []byte) {
+	numBlocks := qrECBlocks[level][version]
+	eccLen := qrECCodewordsPerBlock[level][version]
+	rawCodewords := qrRawModules(version) / 8
+	numShort := numBlocks - rawCodewords%numBlocks
+	shortLen := rawCodewords / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	var blocks [][]byte
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		length := shortLen - eccLen
+		if i >= numShort {
+			length++
+		}
+		block := append([]byte(nil), data[k:k+length]...)
+		k += length
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// Placeholder so all blocks are the same length
+			block = append(block, 0)
+		}
+		blocks = append(blocks, append(block, ecc...))
+	}
+
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a
+// degree, highest coefficient first and the leading 1 dropped
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// newQRCode returns a code of a version with its function
+// patterns drawn
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{Size: size}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format modules until the mask is known
+	q.drawFormat(QRLevelL, 0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			q.setFunction(a, b, bit)
+			q.setFunction(b, a, bit)
+		}
+	}
+
+	return q
+}
+
+func (q *QRCode) setFunction(x, y int, black bool) {
+	q.modules[y][x] = black
+	q.function[y][x] = true
+}
+
+func (q *QRCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.Size || yy < 0 || yy >= q.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+func (q *QRCode) drawFormat(level QRLevel, mask int) {
+	data := qrFormatLevel[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(i))
+	}
+	q.setFunction(8, q.Size-8, true)
+}
+
+// drawCodewords fills the data modules in the zig zag
+// order of the specification
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules picked out by a mask.
+// Applying a mask twice undoes it.
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code would be to scan, by the
+// rules of the specification
+func (q *QRCode) penalty() (penalty int) {
+	size := q.Size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more of the same colour
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			// Patterns that look like finders
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for i, black := range pattern {
+						if at(x+i, y, transpose) != black {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			// 2x2 blocks of the same colour
+			if x+1 < size && y+1 < size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light
+	total := size * size
+	penalty += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+
+	return
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,90 @@
+package epd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeQR(t *testing.T) {
+	// Each text fills a version 1 code at its level, one more
+	// character needs version 2
+	tests := []struct {
+		text  string
+		level QRLevel
+		size  int
+	}{
+		{strings.Repeat("1", 41), QRLevelL, 21},
+		{strings.Repeat("1", 42), QRLevelL, 25},
+		{strings.Repeat("A", 25), QRLevelL, 21},
+		{strings.Repeat("A", 26), QRLevelL, 25},
+		{strings.Repeat("a", 14), QRLevelM, 21},
+		{strings.Repeat("a", 15), QRLevelM, 25},
+		{strings.Repeat("a", 7), QRLevelH, 21},
+		{strings.Repeat("a", 8), QRLevelH, 25},
+		{strings.Repeat("a", 2953), QRLevelL, 177},
+	}
+	for _, test := range tests {
+		code, err := EncodeQR(test.text, test.level)
+		if err != nil {
+			t.Errorf("Encoding %d of %c: %s", len(test.text), test.text[0], err.Error())
+			continue
+		}
+		if code.Size != test.size {
+			t.Errorf("Expected %d of %c to be %d modules, got %d", len(test.text), test.text[0], test.size, code.Size)
+		}
+	}
+
+	if _, err := EncodeQR(strings.Repeat("a", 2954), QRLevelL); err != ErrQRTooLong {
+		t.Errorf("Expected ErrQRTooLong, got %v", err)
+	}
+}
+
+// TestEncodeQRModules checks every module of HELLO WORLD at level Q,
+// a code that scanned with an independent reader
+func TestEncodeQRModules(t *testing.T) {
+	want := []string{
+		"#######....#..#######",
+		"#.....#.##..#.#.....#",
+		"#.###.#..#.##.#.###.#",
+		"#.###.#.#####.#.###.#",
+		"#.###.#.##.#..#.###.#",
+		"#.....#..#..#.#.....#",
+		"#######.#.#.#.#######",
+		"........##.##........",
+		".#.####.##..###.##.#.",
+		"#.####.#....####.###.",
+		"..#.#.##...#..##.....",
+		"#.##.#...#.##...##...",
+		"##.########.###.#####",
+		"........#...#..#.#...",
+		"#######..##..##..####",
+		"#.....#.#.#..#..#.###",
+		"#.###.#.##.#..#...###",
+		"#.###.#.#.###...#.#..",
+		"#.###.#..#....#....##",
+		"#.....#.###..###..##.",
+		"#######..#.#.......#.",
+	}
+	code, err := EncodeQR("HELLO WORLD", QRLevelQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Size != len(want) {
+		t.Fatalf("Expected %d modules, got %d", len(want), code.Size)
+	}
+	for y, row := range want {
+		for x, module := range row {
+			if code.Black(x, y) != (module == '#') {
+				t.Errorf("Expected module %d, %d to be %c", x, y, module)
+			}
+		}
+	}
+}
+
+func TestQRLevelFromString(t *testing.T) {
+	for level, want := range map[string]QRLevel{"l": QRLevelL, "Q": QRLevelQ, "h": QRLevelH, "": QRLevelM, "X": QRLevelM} {
+		if got := QRLevelFromString(level); got != want {
+			t.Errorf("Expected %q to be level %d, got %d", level, want, got)
+		}
+	}
+}
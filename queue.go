@@ -0,0 +1,260 @@
+package epd
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrUpdateSuperseded is the outcome of an update that was
+	// dropped from the queue because a newer one replaced it
+	// before it could be shown.
+	ErrUpdateSuperseded = errors.New("Update superseded by a newer update")
+	// ErrQueueClosed is the outcome of an update that was still
+	// waiting, or submitted, when the queue was closed.
+	ErrQueueClosed = errors.New("Update queue closed")
+)
+
+// Update is a content update waiting in an UpdateQueue.
+// Its outcome is available once it is done.
+type Update struct {
+	Content  RenderContent
+	Template RenderTemplate
+	// useDefault shows with the display's default template
+	useDefault bool
+	done       chan struct{}
+	err        error
+}
+
+func newUpdate(content RenderContent, tpl RenderTemplate, useDefault bool) *Update {
+	return &Update{
+		Content:    content,
+		Template:   tpl,
+		useDefault: useDefault,
+		done:       make(chan struct{}),
+	}
+}
+
+// Done returns a channel that is closed when the update has
+// either been shown, failed or been dropped.
+func (u *Update) Done() <-chan struct{} {
+	return u.done
+}
+
+// Err returns the outcome of the update once it is done.
+// nil means it was shown.
+func (u *Update) Err() error {
+	select {
+	case <-u.done:
+		return u.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the update is done and returns its outcome.
+func (u *Update) Wait() error {
+	<-u.done
+	return u.err
+}
+
+func (u *Update) finish(err error) {
+	u.err = err
+	close(u.done)
+}
+
+// UpdateQueue feeds updates to a display one at a time.
+// A panel refresh takes seconds, so rather than queueing every
+// update it holds at most one pending update. Submitting a new
+// one while another is still waiting replaces it, and the
+// replaced update finishes with ErrUpdateSuperseded. The newest
+// content always wins.
+//
+// If the display is a FrameDisplay, updates are pipelined. The
+// next update is rendered while the panel is still refreshing
+// the last, and is sent as soon as the panel is free. Only one
+// frame is ever rendered ahead, and a rendered frame that is
+// still waiting for the panel is superseded by newer updates
+// just like a pending one.
+type UpdateQueue struct {
+	display Display
+	mu      sync.Mutex
+	pending *Update
+	closed  bool
+	wake    chan struct{}
+	stopped chan struct{}
+}
+
+// NewUpdateQueue creates a queue feeding the display and starts
+// working through it. Close it when done.
+func NewUpdateQueue(display Display) *UpdateQueue {
+	q := &UpdateQueue{
+		display: display,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Show queues an update using the display's default template.
+func (q *UpdateQueue) Show(content RenderContent) *Update {
+	return q.submit(newUpdate(content, TplDefaultAuto, true))
+}
+
+// ShowWithTemplate queues an update using the given template.
+func (q *UpdateQueue) ShowWithTemplate(content RenderContent, tpl RenderTemplate) *Update {
+	return q.submit(newUpdate(content, tpl, false))
+}
+
+// Close stops the queue. Any pending update finishes with
+// ErrQueueClosed. Close waits for an update already being
+// shown to complete.
+func (q *UpdateQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.stopped
+		return
+	}
+	q.closed = true
+	pending := q.pending
+	q.pending = nil
+	close(q.wake)
+	q.mu.Unlock()
+
+	if pending != nil {
+		pending.finish(ErrQueueClosed)
+	}
+	<-q.stopped
+}
+
+func (q *UpdateQueue) submit(update *Update) *Update {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		update.finish(ErrQueueClosed)
+		return update
+	}
+	replaced := q.pending
+	q.pending = update
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	q.mu.Unlock()
+
+	if replaced != nil {
+		replaced.finish(ErrUpdateSuperseded)
+	}
+	return update
+}
+
+// take removes the pending update from the queue
+func (q *UpdateQueue) take() *Update {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	update := q.pending
+	q.pending = nil
+	return update
+}
+
+func (q *UpdateQueue) run() {
+	defer close(q.stopped)
+
+	if frameDisplay, ok := q.display.(FrameDisplay); ok {
+		q.runPipelined(frameDisplay)
+		return
+	}
+
+	for range q.wake {
+		update := q.take()
+		if update == nil {
+			continue
+		}
+		update.finish(q.show(update))
+	}
+}
+
+func (q *UpdateQueue) show(update *Update) error {
+	if update.useDefault {
+		return q.display.Show(update.Content)
+	}
+	return q.display.ShowWithTemplate(update.Content, update.Template)
+}
+
+// renderedUpdate is an update whose frame is ready to send
+type renderedUpdate struct {
+	update *Update
+	frame  Frame
+}
+
+// runPipelined renders updates on this goroutine and hands
+// them to a second goroutine that sends them to the panel.
+// The hand off is unbuffered, so rendering waits for the
+// panel rather than racing ahead of it.
+func (q *UpdateQueue) runPipelined(display FrameDisplay) {
+
+	frames := make(chan renderedUpdate)
+	sent := make(chan struct{})
+
+	go func() {
+		defer close(sent)
+		for rendered := range frames {
+			rendered.update.finish(display.ShowFrame(rendered.frame))
+		}
+	}()
+
+	defer func() {
+		close(frames)
+		<-sent
+	}()
+
+	for range q.wake {
+		update := q.take()
+		for update != nil {
+			rendered, err := q.render(display, update)
+			if err != nil {
+				update.finish(err)
+				break
+			}
+
+			var open bool
+			if update, open = q.handOff(frames, rendered); !open {
+				return
+			}
+		}
+	}
+}
+
+// handOff waits for the panel to be free to send the rendered
+// update. If something newer arrives while waiting, the rendered
+// update is superseded and the newer update is returned to be
+// rendered instead. open is false if the queue was closed.
+func (q *UpdateQueue) handOff(frames chan<- renderedUpdate, rendered renderedUpdate) (newer *Update, open bool) {
+	for {
+		select {
+		case frames <- rendered:
+			return nil, true
+		case _, open = <-q.wake:
+			if !open {
+				rendered.update.finish(ErrQueueClosed)
+				return
+			}
+			if newer = q.take(); newer != nil {
+				rendered.update.finish(ErrUpdateSuperseded)
+				return
+			}
+		}
+	}
+}
+
+func (q *UpdateQueue) render(display FrameDisplay, update *Update) (rendered renderedUpdate, err error) {
+	tpl := update.Template
+	if update.useDefault {
+		tpl = display.DefaultTemplate()
+	}
+	rendered.update = update
+	rendered.frame, err = display.RenderFrame(update.Content, tpl)
+	return
+}
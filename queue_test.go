@@ -0,0 +1,252 @@
+package epd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDisplay is a FrameDisplay whose frames are the "n" of their
+// content. Renders and shows are announced on channels, and each
+// render and show waits to be let through, so tests can hold the
+// queue at any step.
+type fakeDisplay struct {
+	mu    sync.Mutex
+	shown []string
+
+	rendering chan string
+	showing   chan string
+	// renderGate and showGate let renders and shows finish
+	renderGate chan struct{}
+	showGate   chan struct{}
+}
+
+func newFakeDisplay() *fakeDisplay {
+	gate := make(chan struct{})
+	close(gate)
+	return &fakeDisplay{
+		rendering:  make(chan string, 16),
+		showing:    make(chan string, 16),
+		renderGate: gate,
+		showGate:   make(chan struct{}),
+	}
+}
+
+func (d *fakeDisplay) Show(content RenderContent) error {
+	return d.ShowWithTemplate(content, d.DefaultTemplate())
+}
+
+func (d *fakeDisplay) ShowWithTemplate(content RenderContent, tpl RenderTemplate) error {
+	frame, err := d.RenderFrame(content, tpl)
+	if err != nil {
+		return err
+	}
+	return d.ShowFrame(frame)
+}
+
+func (d *fakeDisplay) DefaultTemplate() RenderTemplate {
+	return TplDefaultAuto
+}
+
+func (d *fakeDisplay) RenderFrame(content RenderContent, tpl RenderTemplate) (frame Frame, err error) {
+	n, _ := content["n"].(string)
+	d.rendering <- n
+	<-d.renderGate
+	return Frame{Black: []byte(n)}, nil
+}
+
+func (d *fakeDisplay) ShowFrame(frame Frame) error {
+	d.showing <- string(frame.Black)
+	<-d.showGate
+	d.mu.Lock()
+	d.shown = append(d.shown, string(frame.Black))
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *fakeDisplay) Clear() error { return nil }
+func (d *fakeDisplay) Width() int   { return 400 }
+func (d *fakeDisplay) Height() int  { return 300 }
+
+// release lets the show in progress finish
+func (d *fakeDisplay) release() {
+	d.showGate <- struct{}{}
+}
+
+func (d *fakeDisplay) shownFrames() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.shown...)
+}
+
+// plainDisplay hides that a display can render frames, so the
+// queue shows updates one whole update at a time
+type plainDisplay struct {
+	Display
+}
+
+func expectStep(t *testing.T, steps <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-steps:
+		if got != want {
+			t.Fatalf("Expected %s next, got %s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %s, but the queue is stuck", want)
+	}
+}
+
+func expectOutcome(t *testing.T, update *Update, want error) {
+	t.Helper()
+	select {
+	case <-update.Done():
+		if err := update.Err(); err != want {
+			t.Errorf("Expected update %s to end with %v, got %v", update.Content["n"], want, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Update %s never finished", update.Content["n"])
+	}
+}
+
+func show(q *UpdateQueue, n string) *Update {
+	return q.Show(RenderContent{"n": n})
+}
+
+func TestUpdateQueueNewestWins(t *testing.T) {
+	display := newFakeDisplay()
+	q := NewUpdateQueue(plainDisplay{display})
+	defer q.Close()
+
+	first := show(q, "1")
+	expectStep(t, display.showing, "1")
+
+	// Updates arriving while the panel is busy replace each other
+	second, third, fourth := show(q, "2"), show(q, "3"), show(q, "4")
+	expectOutcome(t, second, ErrUpdateSuperseded)
+	expectOutcome(t, third, ErrUpdateSuperseded)
+
+	display.release()
+	expectOutcome(t, first, nil)
+	expectStep(t, display.showing, "4")
+	display.release()
+	expectOutcome(t, fourth, nil)
+
+	if shown := display.shownFrames(); !reflect.DeepEqual(shown, []string{"1", "4"}) {
+		t.Errorf("Expected 1 and 4 shown, got %v", shown)
+	}
+}
+
+func TestUpdateQueuePipelined(t *testing.T) {
+	display := newFakeDisplay()
+	q := NewUpdateQueue(display)
+	defer q.Close()
+
+	first := show(q, "1")
+	expectStep(t, display.rendering, "1")
+	expectStep(t, display.showing, "1")
+
+	// The next update is rendered while the panel is busy, and
+	// waits to be handed off
+	second := show(q, "2")
+	expectStep(t, display.rendering, "2")
+
+	// A newer update supersedes the rendered frame
+	third := show(q, "3")
+	expectOutcome(t, second, ErrUpdateSuperseded)
+	expectStep(t, display.rendering, "3")
+
+	display.release()
+	expectOutcome(t, first, nil)
+	expectStep(t, display.showing, "3")
+	display.release()
+	expectOutcome(t, third, nil)
+
+	if shown := display.shownFrames(); !reflect.DeepEqual(shown, []string{"1", "3"}) {
+		t.Errorf("Expected 1 and 3 shown, got %v", shown)
+	}
+}
+
+func TestUpdateQueueClose(t *testing.T) {
+	for _, pipelined := range []bool{false, true} {
+		display := newFakeDisplay()
+		var q *UpdateQueue
+		if pipelined {
+			q = NewUpdateQueue(display)
+		} else {
+			q = NewUpdateQueue(plainDisplay{display})
+		}
+
+		first := show(q, "1")
+		expectStep(t, display.rendering, "1")
+		expectStep(t, display.showing, "1")
+		second := show(q, "2")
+		if pipelined {
+			// Closing while the frame waits to be handed off
+			expectStep(t, display.rendering, "2")
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			q.Close()
+			close(closed)
+		}()
+		expectOutcome(t, second, ErrQueueClosed)
+
+		// Close waits for the update being shown
+		select {
+		case <-closed:
+			t.Error("Close returned while an update was being shown")
+		case <-time.After(20 * time.Millisecond):
+		}
+		display.release()
+		expectOutcome(t, first, nil)
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close never returned")
+		}
+
+		expectOutcome(t, show(q, "3"), ErrQueueClosed)
+		q.Close()
+	}
+}
+
+func TestUpdateQueueCloseWhileRendering(t *testing.T) {
+	display := newFakeDisplay()
+	display.renderGate = make(chan struct{})
+	q := NewUpdateQueue(display)
+
+	update := show(q, "1")
+	expectStep(t, display.rendering, "1")
+
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	close(display.renderGate)
+
+	// The frame either gets to the panel before the queue stops or
+	// is dropped, but the update finishes and Close returns either way
+	select {
+	case step := <-display.showing:
+		if step != "1" {
+			t.Fatalf("Expected 1 shown, got %s", step)
+		}
+		display.release()
+		expectOutcome(t, update, nil)
+	case <-update.Done():
+		if err := update.Err(); err != ErrQueueClosed {
+			t.Errorf("Expected the update to end with ErrQueueClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The update never finished")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close never returned")
+	}
+}
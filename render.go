@@ -1,22 +1,16 @@
 package epd
 
 import (
-	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"io/ioutil"
 	"math"
-	"strings"
 
 	rice "github.com/GeertJohan/go.rice"
-	"github.com/disintegration/imaging"
 	dither "github.com/esimov/dithergo"
-	"github.com/golang/freetype/truetype"
 	"github.com/kjk/flex"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/image/font"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -54,33 +48,66 @@ var floydSteinbergDither = dither.Dither{
 }
 
 type flexRenderEngine struct {
-	font     *truetype.Font
+	fonts    *FontRegistry
 	fontSize float64
 	dpi      float64
+	// debug draws the layout over rendered images
+	debug bool
 }
 
+// NewFlexRenderEngine returns an engine that renders text in
+// fontfile, or the bundled wqy-microhei font if no file is given.
+// The font is registered as the default family, alongside the
+// go and mono families.
 func NewFlexRenderEngine(defaultFontSize float64, dpi float64, fontfile ...string) (r flexRenderEngine, err error) {
 
-	var font *truetype.Font
-	var fontBytes []byte
+	fonts := NewFontRegistry()
 
 	if len(fontfile) > 0 {
-		fontBytes, err = ioutil.ReadFile(fontfile[0])
+		if err = fonts.LoadFile(FontFamilyDefault, FontWeightNormal, FontStyleNormal, fontfile[0]); err != nil {
+			return
+		}
+	} else if fontbox, errr := rice.FindBox("res/fonts"); errr != nil {
+		log.Warnf("Bundled fonts not found, using the go font: %s", errr.Error())
+	} else {
+		var fontBytes []byte
+		if fontBytes, err = fontbox.Bytes("wqy-microhei.ttc"); err != nil {
+			return
+		}
+		var source FontSource
+		if source, err = ParseTrueType(fontBytes); err != nil {
+			return
+		}
+		fonts.Register(FontFamilyDefault, FontWeightNormal, FontStyleNormal, source)
 	}
 
-	if fontBytes == nil {
-		fontbox := rice.MustFindBox("res/fonts")
-		fontBytes, err = fontbox.Bytes("wqy-microhei.ttc")
-	}
+	return NewFlexRenderEngineWithFonts(defaultFontSize, dpi, fonts), nil
 
-	font, _ = truetype.Parse(fontBytes)
+}
 
+// NewFlexRenderEngineWithFonts returns an engine that renders
+// text in the fonts of a registry. Nodes pick fonts from it
+// with their fontFamily, fontWeight and fontStyle.
+func NewFlexRenderEngineWithFonts(defaultFontSize float64, dpi float64, fonts *FontRegistry) flexRenderEngine {
 	return flexRenderEngine{
-		font:     font,
+		fonts:    fonts,
 		fontSize: defaultFontSize,
 		dpi:      dpi,
-	}, nil
+	}
+}
+
+// WithDebug returns a copy of the engine that draws the box,
+// padding and id of each node over the images it renders, so
+// template authors can see how a template was laid out.
+func (r flexRenderEngine) WithDebug(debug bool) flexRenderEngine {
+	r.debug = debug
+	return r
+}
 
+// Fonts returns the engine's font registry, which more
+// fonts can be registered with
+func (r flexRenderEngine) Fonts() *FontRegistry {
+	return r.fonts
 }
 
 var (
@@ -100,15 +127,15 @@ var (
 			{
 				"type": "div",
 				"flexDirection": "row",
-				"justifyContent": "space-evenly",
+				"justifyContent": "flex-start",
 				"alignItems": "stretch",
-				"alignContent": "stetch",
+				"alignContent": "stretch",
 				"children": [
 					{
 						"id": "body",
 						"padding":10,
 						"type": "text",
-						"fontsize": 1,
+						"fontSize": 1,
 						"flexDirection":"column",
 						"flexGrow":1
 					}
@@ -132,7 +159,7 @@ var (
 				"id": "footer",
 				"padding":10,
 				"type": "text",
-				"fontsize": 1
+				"fontSize": 1
 			}
 		]
 	}`
@@ -155,7 +182,7 @@ var (
 						"id": "body",
 						"padding":10,
 						"type": "text",
-						"fontsize": 1
+						"fontSize": 1
 					},
 					{
 						"id": "img",
@@ -167,7 +194,7 @@ var (
 				"id": "footer",
 				"padding":10,
 				"type": "text",
-				"fontsize": 1
+				"fontSize": 1
 			}
 		]
 	}`
@@ -187,52 +214,125 @@ func resolveTemplate(tpl RenderTemplate, width, height int) (resolved RenderTemp
 
 func (r flexRenderEngine) Render(content RenderContent, width, height int, layout RenderTemplate) (img image.Image, err error) {
 
+	flexNode, err := r.layout(content, width, height, layout)
+	if err != nil {
+		return
+	}
+
+	// Iterate flex nodes and render them to a canvas
+	colWhite := color.RGBA{255, 255, 255, 255}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{colWhite}, image.ZP, draw.Src)
+
+	if log.GetLevel() == log.DebugLevel {
+		r.printLayout(flexNode, 0)
+	}
+
+	r.RenderNode(flexNode, image.Point{X: 0, Y: 0}, dst)
+
+	if r.debug {
+		r.drawDebug(flexNode, image.Point{X: 0, Y: 0}, dst)
+	}
+
+	return dst, nil
+}
+
+// layout parses the template, binds the content to it and lays
+// it out in width by height pixels
+func (r flexRenderEngine) layout(content RenderContent, width, height int, layout RenderTemplate) (flexNode *flex.Node, err error) {
+
 	// Determine layout template
 	// If a default, work out default for aspect ratio
 	// If a specific default use that
 	// If a template, inflate it directly
 	tpl := resolveTemplate(layout, width, height)
 
-	// Unmarshal the template
-	root := Node{}
-	err = json.Unmarshal([]byte(tpl), &root)
+	root, err := ParseTemplate(tpl)
 	if err != nil {
 		return
 	}
 
-	// Populate the content
-	for id, item := range content {
-		node, err := root.FindNodeById(id)
-		if err == nil {
-			x, isString := item.(string)
-			if isString {
-				if x != "" {
-					node.Content = x
-				}
-			} else {
-				node.Content = item
-			}
-		}
+	if err = interpolate(root, content); err != nil {
+		return
+	}
+
+	if err = bindContent(root, content); err != nil {
+		return
+	}
+
+	if err = prepareContent(root); err != nil {
+		return
 	}
 
 	// Layout the node structure
 	config := flex.ConfigGetDefault()
 	config.Context = r
-	flexNode := root.Inflate(config)
+	flexNode = root.Inflate(config)
 	flex.CalculateLayout(flexNode, float32(width), float32(height), flex.DirectionLTR)
+	r.fitText(flexNode)
 
-	// Iterate flex nodes and render them to a canvas
-	colWhite := color.RGBA{255, 255, 255, 255}
-	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.Draw(dst, dst.Bounds(), &image.Uniform{colWhite}, image.ZP, draw.Src)
+	return
+}
 
-	if log.GetLevel() == log.DebugLevel {
-		r.printLayout(flexNode, 0)
+// bindContent populates the content nodes of the template
+// with the matching content by id. Content with no matching
+// node is ignored, it may only be there for expressions or
+// repeaters in the template. Empty strings leave the node as it is, and numbers
+// and bools are shown as text.
+func bindContent(root *Node, content RenderContent) (err error) {
+	for id, item := range content {
+		node, errr := root.FindNodeById(id)
+		if errr != nil || node.repeatKey() == id {
+			continue
+		}
+		if node.isChart() {
+			node.Content = item
+			continue
+		}
+		if node.Type == NodeTypeTable {
+			if node.Content, err = tableRows(item, node.Columns); err != nil {
+				return &ContentError{ID: id, Err: err}
+			}
+			continue
+		}
+		switch x := item.(type) {
+		case string:
+			if x != "" {
+				node.Content = x
+			}
+		case nil, image.Image:
+			node.Content = item
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, fmt.Stringer:
+			node.Content = fmt.Sprint(x)
+		default:
+			return &ContentError{
+				ID:  id,
+				Err: fmt.Errorf("unsupported content type %T", item),
+			}
+		}
 	}
+	return
+}
 
-	r.RenderNode(flexNode, image.Point{X: 0, Y: 0}, dst)
-
-	return dst, nil
+// prepareContent converts the bound content of nodes that
+// draw it as something other than text, such as codes, charts
+// and svgs, so content errors are found before layout.
+func prepareContent(node *Node) (err error) {
+	if err = node.prepareCode(); err != nil {
+		return
+	}
+	if err = node.prepareChart(); err != nil {
+		return
+	}
+	if err = node.prepareSVG(); err != nil {
+		return
+	}
+	for _, child := range node.Children {
+		if err = prepareContent(child); err != nil {
+			return
+		}
+	}
+	return
 }
 
 func (r flexRenderEngine) Measure(flexNode *flex.Node, width float32, widthMode flex.MeasureMode, height float32, heightMode flex.MeasureMode) (size flex.Size) {
@@ -242,13 +342,36 @@ func (r flexRenderEngine) Measure(flexNode *flex.Node, width float32, widthMode
 
 	switch x := node.Content.(type) {
 	case string:
-		requestWidth, requestHeight := r.MeasureText(x, node.FontSize, float64(width))
-		outWidth = requestWidth + float64(node.Padding*2)
-		outHeight = requestHeight + float64(node.Padding*2)
+		if node.code != nil {
+			outWidth, outHeight = node.measureCode()
+			break
+		}
+		style := node.TextStyle()
+		if node.Fit == FitShrink && node.MaxFontSize > 0 {
+			style.FontScale = node.MaxFontSize
+		}
+		requestWidth, requestHeight := r.MeasureText(x, style, float64(width))
+		outWidth = requestWidth
+		outHeight = requestHeight
 	case image.Image:
 		requestWidth, requestHeight := r.MeasureImage(x, float64(width), widthMode, float64(height), heightMode)
 		outWidth = requestWidth
 		outHeight = requestHeight
+	case *svgImage:
+		outWidth, outHeight = measureAspect(x.width, x.height, float64(width), widthMode, float64(height), heightMode)
+	default:
+		if node.isChart() {
+			outWidth, outHeight = measureChart(float64(width), widthMode, float64(height), heightMode)
+		} else if node.Type == NodeTypeTable {
+			rows, _ := tableRows(x, node.Columns)
+			tableWidth := math.Inf(1)
+			if widthMode != flex.MeasureModeUndefined {
+				tableWidth = float64(width)
+			}
+			table := r.layoutTable(node, rows, tableWidth)
+			outWidth = table.width
+			outHeight = table.height
+		}
 	}
 
 	if widthMode == flex.MeasureModeAtMost || widthMode == flex.MeasureModeExactly {
@@ -258,7 +381,7 @@ func (r flexRenderEngine) Measure(flexNode *flex.Node, width float32, widthMode
 		outHeight = cap(outHeight, float64(height))
 	}
 
-	fmt.Printf("Measure called for %s. [%.2f, %.2f][%v,%v] = [%.2f, %.2f]\n",
+	log.Tracef("Measure called for %s. [%.2f, %.2f][%v,%v] = [%.2f, %.2f]",
 		node.ID,
 		width,
 		height,
@@ -283,8 +406,12 @@ func cap(toCap, cap float64) float64 {
 }
 
 func (r flexRenderEngine) MeasureImage(img image.Image, hintWidth float64, modeWidth flex.MeasureMode, hintHeight float64, modeHeight flex.MeasureMode) (width, height float64) {
+	return measureAspect(float64(img.Bounds().Dx()), float64(img.Bounds().Dy()), hintWidth, modeWidth, hintHeight, modeHeight)
+}
 
-	imgWidth, imgHeight := float64(img.Bounds().Size().X), float64(img.Bounds().Size().Y)
+// measureAspect is the size of something imgWidth by imgHeight
+// scaled to the space flex gives it, keeping its aspect ratio
+func measureAspect(imgWidth, imgHeight, hintWidth float64, modeWidth flex.MeasureMode, hintHeight float64, modeHeight flex.MeasureMode) (width, height float64) {
 
 	if modeWidth == flex.MeasureModeUndefined && modeHeight == flex.MeasureModeUndefined {
 		return imgWidth, imgHeight
@@ -315,93 +442,208 @@ func scaleToTarget(target, base, applyTo float64) (applied float64) {
 	return applyTo * scale
 }
 
-func (r flexRenderEngine) MeasureText(text string, fontScale float64, pixelWidth float64) (width, height float64) {
+// MeasureText returns the size of text laid out in the
+// style, wrapped to pixelWidth.
+func (r flexRenderEngine) MeasureText(text string, style TextStyle, pixelWidth float64) (width, height float64) {
 
 	if text == "" {
 		return 0, 0
 	}
 
-	var face font.Face
-
-	face = truetype.NewFace(r.font, &truetype.Options{
-		Size:    fontScale * r.fontSize,
-		DPI:     r.dpi,
-		Hinting: font.HintingFull,
-	})
-
-	lineHeightPt := Int26_6ToFloat64(face.Metrics().Height)
-	lineHeightPx := PointsToPixels(lineHeightPt, r.dpi)
-
-	paras := strings.Split(text, "\n")
-	var maxWidthPx float64
-	var heightPx float64 = 0
-
-	// Loop over paras. Split into lines
-	for _, para := range paras {
-		minIdx := 0
-		maxIdx := 1
-		numChars := len(para)
-		var lastWidth float64
-		for {
-			str := para[minIdx:maxIdx]
-			line := strings.TrimSpace(str)
-			width26_6 := font.MeasureString(face, line)
-			widthPt := Int26_6ToFloat64(width26_6)
-			widthPx := PointsToPixels(widthPt, r.dpi)
-			if widthPx >= pixelWidth {
-				maxWidthPx = math.Max(lastWidth, maxWidthPx)
-				minIdx = maxIdx - 1
-				heightPx += lineHeightPx
-				continue
-			} else {
-				if maxIdx == numChars {
-					maxWidthPx = math.Max(widthPx, maxWidthPx)
-					heightPx += lineHeightPx
-					break
-				}
-				lastWidth = widthPx
-				maxIdx = maxIdx + 1
-			}
-		}
-		heightPx += lineHeightPx
+	if style.Format == TextFormatMarkdown {
+		layout := r.layoutMarkdown(text, style, pixelWidth, math.Inf(1))
+		return layout.width, layout.height
 	}
 
-	return maxWidthPx, heightPx
+	layout := layoutText(r.textFace(style), text, style, pixelWidth, math.Inf(1))
+
+	return layout.width, layout.height
+
+}
 
+// textFace returns a face from the engine's fonts to
+// measure and draw text in the style
+func (r flexRenderEngine) textFace(style TextStyle) textFace {
+	size := style.FontScale * r.fontSize
+	face := textFace{
+		Face:          r.fonts.Face(style.FontFamily, style.FontWeight, style.FontStyle, size, r.dpi),
+		letterSpacing: style.LetterSpacing,
+		family:        style.FontFamily,
+		weight:        style.FontWeight,
+		style:         FontStyleFromString(style.FontStyle),
+	}
+	// Faces of size 0 are 12 points
+	if size == 0 {
+		size = 12
+	}
+	face.size = size * r.dpi / 72
+	return face
 }
 
 func (r flexRenderEngine) RenderNode(flexNode *flex.Node, offset image.Point, dst *image.RGBA) {
 
 	node, _ := flexNode.Context.(*Node)
-	content := node.Content
-	rect := image.Rectangle{
-		Min: image.Point{
-			X: offset.X + int(flexNode.LayoutGetLeft()) + int(flexNode.LayoutGetPadding(flex.EdgeLeft)),
-			Y: offset.Y + int(flexNode.LayoutGetTop()) + int(flexNode.LayoutGetPadding(flex.EdgeTop)),
-		},
-		Max: image.Point{
-			X: offset.X + int(flexNode.LayoutGetLeft()) + int(flexNode.LayoutGetWidth()) - int(flexNode.LayoutGetPadding(flex.EdgeRight)),
-			Y: offset.Y + int(flexNode.LayoutGetTop()) + int(flexNode.LayoutGetHeight()) - int(flexNode.LayoutGetPadding(flex.EdgeBottom)),
-		},
+	box := roundedRect{
+		rect:   boxRect(flexNode, offset),
+		radius: float64(node.BorderRadius),
 	}
+	rect := contentRect(flexNode, offset)
 
 	log.Debugf("Node %s: [%d,%d][%d,%d]", node.ID, rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y)
 
-	switch x := content.(type) {
+	if background := ColorFromString(node.BackgroundColor); background != nil {
+		fillRoundedRect(dst, box, background)
+	}
+	if border := int(flexNode.LayoutGetBorder(flex.EdgeLeft)); border > 0 {
+		strokeRoundedRect(dst, box, border, node.borderColor())
+	}
+
+	r.drawContent(node, rect, dst)
+
+	for _, child := range flexNode.Children {
+		r.RenderNode(child, box.rect.Min, dst)
+	}
+
+	if node.Invert {
+		invertRect(dst, box)
+	}
+
+}
+
+// borderColor is the colour of a node's border, black by default
+func (n *Node) borderColor() color.Color {
+	if c := ColorFromString(n.BorderColor); c != nil {
+		return c
+	}
+	return ColorBlack
+}
+
+// drawContent draws the content of a node into its content box
+func (r flexRenderEngine) drawContent(node *Node, rect image.Rectangle, dst *image.RGBA) {
+	switch x := node.Content.(type) {
 	case image.Image:
-		r.drawImage(x, rect, dst)
+		r.drawImage(node, x, rect, dst)
+	case *svgImage:
+		r.drawSVG(node, x, rect, dst)
+	case nil:
 	case string:
-		r.drawText(x, node.FontSize, rect, dst)
+		if node.code != nil {
+			r.drawCode(node, rect, dst)
+			break
+		}
+		if r.drawText(x, node.TextStyle(), rect, dst) {
+			log.Warnf("Text of node %s overflows its box [%d, %d]", node.ID, rect.Dx(), rect.Dy())
+		}
+	default:
+		if node.isChart() {
+			r.drawChart(node, rect, dst)
+		} else if node.Type == NodeTypeTable {
+			if rows, err := tableRows(x, node.Columns); err != nil {
+				log.Warnf("Table %s: %s", node.ID, err.Error())
+			} else {
+				r.drawTable(node, rows, rect, dst)
+			}
+		}
 	}
+}
 
-	pt := image.Point{
+// boxRect is the laid out box of a node, including its
+// border and padding. offset is the position of its parent.
+func boxRect(flexNode *flex.Node, offset image.Point) image.Rectangle {
+	min := image.Point{
 		X: offset.X + int(flexNode.LayoutGetLeft()),
 		Y: offset.Y + int(flexNode.LayoutGetTop()),
 	}
+	return image.Rectangle{
+		Min: min,
+		Max: min.Add(image.Point{
+			X: int(flexNode.LayoutGetWidth()),
+			Y: int(flexNode.LayoutGetHeight()),
+		}),
+	}
+}
+
+// contentRect is the box of a node inside its border and
+// padding, where its content is drawn
+func contentRect(flexNode *flex.Node, offset image.Point) image.Rectangle {
+	box := boxRect(flexNode, offset)
+	inset := func(edge flex.Edge) int {
+		return int(flexNode.LayoutGetBorder(edge) + flexNode.LayoutGetPadding(edge))
+	}
+	return image.Rectangle{
+		Min: image.Point{
+			X: box.Min.X + inset(flex.EdgeLeft),
+			Y: box.Min.Y + inset(flex.EdgeTop),
+		},
+		Max: image.Point{
+			X: box.Max.X - inset(flex.EdgeRight),
+			Y: box.Max.Y - inset(flex.EdgeBottom),
+		},
+	}
+}
+
+// fitText sizes the text of nodes with a fit mode to their
+// laid out boxes. Their measured size is at their largest font
+// size, so this only ever shrinks text.
+func (r flexRenderEngine) fitText(flexNode *flex.Node) {
+
+	node := flexNode.Context.(*Node)
+	if text, ok := node.Content.(string); ok && node.Fit == FitShrink {
+		rect := contentRect(flexNode, image.Point{})
+		node.fittedFontSize = r.FitText(text, node.TextStyle(), node.MinFontSize, node.MaxFontSize, float64(rect.Dx()), float64(rect.Dy()))
+	}
+
 	for _, child := range flexNode.Children {
-		r.RenderNode(child, pt, dst)
+		r.fitText(child)
+	}
+}
+
+// FitText returns the largest font size between minSize and
+// maxSize at which the text, laid out in the style, fits within
+// width and height. If it doesn't fit even at minSize, minSize
+// is returned. maxSize defaults to the style's size, and minSize
+// to a quarter of that.
+func (r flexRenderEngine) FitText(text string, style TextStyle, minSize, maxSize, width, height float64) float64 {
+
+	if maxSize <= 0 {
+		maxSize = math.Max(style.FontScale, 1)
+	}
+	if minSize <= 0 || minSize > maxSize {
+		minSize = maxSize / 4
+	}
+
+	fits := func(size float64) bool {
+		style.FontScale = size
+		if style.Format == TextFormatMarkdown {
+			layout := r.layoutMarkdown(text, style, width, math.Inf(1))
+			if style.MaxLines > 0 && layout.overflow {
+				return false
+			}
+			return layout.width <= width && layout.height <= height
+		}
+		layout := layoutText(r.textFace(style), text, style, width, math.Inf(1))
+		if style.MaxLines > 0 && layout.overflow {
+			return false
+		}
+		return layout.width <= width && layout.height <= height
 	}
 
+	if fits(maxSize) {
+		return maxSize
+	}
+
+	// Binary search to within a hundredth of the default size
+	low, high := minSize, maxSize
+	for high-low > 0.01 {
+		mid := (low + high) / 2
+		if fits(mid) {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return low
 }
 
 func (r flexRenderEngine) printLayout(node *flex.Node, level int) {
@@ -423,121 +665,31 @@ func (r flexRenderEngine) printLayout(node *flex.Node, level int) {
 	}
 }
 
-func (r flexRenderEngine) drawText(text string, scale float64, bounds image.Rectangle, dst *image.RGBA) {
+// drawText draws text in the style into bounds. It returns
+// true if the text had more lines than fit.
+func (r flexRenderEngine) drawText(text string, style TextStyle, bounds image.Rectangle, dst *image.RGBA) (overflow bool) {
 
 	if text == "" {
 		return
 	}
 
-	var face font.Face
-
-	face = truetype.NewFace(r.font, &truetype.Options{
-		Size:       scale * r.fontSize,
-		DPI:        r.dpi,
-		Hinting:    font.HintingNone,
-		SubPixelsX: 16,
-		SubPixelsY: 16,
-	})
-
-	paras := strings.Split(text, "\n")
-	var maxWidthPx float64
-
-	pixelWidth := float64(bounds.Size().X)
-	colBlack := color.RGBA{0, 0, 0, 255}
-
-	xPts := int(PixelsToPoints(float64(bounds.Min.X), r.dpi))
-	yPts := int(PixelsToPoints(float64(bounds.Min.Y), r.dpi))
-	dotX := fixed.I(xPts)
-	dotY := fixed.I(yPts) + face.Metrics().Ascent
-	draw := &font.Drawer{
-		Dst:  dst,
-		Src:  &image.Uniform{colBlack},
-		Face: face,
-		Dot: fixed.Point26_6{
-			X: dotX,
-			Y: dotY,
-		},
+	textColor := style.Color
+	if textColor == nil {
+		textColor = ColorBlack
 	}
 
-	// Loop over paras. Split into lines
-	for _, para := range paras {
-		minIdx := 0
-		maxIdx := 1
-		numChars := len(para)
-		var lastWidth float64
-		var lastLine string
-		for {
-			str := para[minIdx:maxIdx]
-			line := strings.TrimSpace(str)
-			width26_6 := font.MeasureString(face, line)
-			widthPt := Int26_6ToFloat64(width26_6)
-			widthPx := PointsToPixels(widthPt, r.dpi)
-			if widthPx > pixelWidth {
-				maxWidthPx = math.Max(lastWidth, maxWidthPx)
-				minIdx = maxIdx - 1
-				draw.DrawBytes([]byte(lastLine))
-				draw.Dot.Y = draw.Dot.Y + face.Metrics().Height
-				draw.Dot.X = dotX
-				continue
-			} else {
-				if maxIdx == numChars {
-					maxWidthPx = math.Max(lastWidth, maxWidthPx)
-					draw.DrawBytes([]byte(line))
-					draw.Dot.Y = draw.Dot.Y + face.Metrics().Height
-					draw.Dot.X = dotX
-					break
-				}
-				lastWidth = widthPx
-				lastLine = line
-				maxIdx = maxIdx + 1
-			}
-		}
-		draw.Dot.Y = draw.Dot.Y + face.Metrics().Height
-		draw.Dot.X = dotX
+	if style.Format == TextFormatMarkdown {
+		layout := r.layoutMarkdown(text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+		drawMarkdown(layout, style, &image.Uniform{textColor}, bounds, dst)
+		return layout.overflow
 	}
 
-}
-
-func (r flexRenderEngine) drawImage(img image.Image, bounds image.Rectangle, dst *image.RGBA) {
-
-	targetWidth, targetHeight := float64(bounds.Size().X), float64(bounds.Size().Y)
-	width := float64(img.Bounds().Size().X)
-	height := float64(img.Bounds().Size().Y)
-	scaledHeight := scaleToTarget(targetWidth, width, height)
-	scaledWidth := scaleToTarget(targetHeight, height, width)
-	var resizeWidth, resizeHeight int
-	if scaledHeight <= targetHeight {
-		resizeWidth = int(targetWidth)
-		resizeHeight = int(scaledHeight)
-	} else if scaledWidth <= targetWidth {
-		resizeWidth = int(scaledWidth)
-		resizeHeight = int(targetHeight)
-	} else {
-		resizeWidth = int(targetWidth)
-		resizeHeight = int(targetHeight)
-	}
-
-	var xOffset, yOffset int
-	xOffset = int((targetWidth / 2)) - (resizeWidth / 2)
-	yOffset = int((targetHeight / 2)) - (resizeHeight / 2)
-	newBounds := image.Rectangle{
-		image.Point{
-			X: bounds.Min.X + xOffset,
-			Y: bounds.Min.Y + yOffset,
-		},
-		image.Point{
-			X: bounds.Min.X + xOffset + resizeWidth,
-			Y: bounds.Min.Y + yOffset + resizeHeight,
-		},
-	}
+	face := r.textFace(style)
+	layout := layoutText(face, text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+	drawLayout(face, layout, style, &image.Uniform{textColor}, bounds, dst)
 
-	log.Debugf("Scaling image to [%.0f, %.0f]", targetWidth, targetHeight)
+	return layout.overflow
 
-	updateImg := imaging.Resize(img, resizeWidth, resizeHeight, imaging.Lanczos)
-	multiplier := 0.3 // Smaller for smaller images
-	// outputMono := floydSteinbergDither.Monochrome(updateImg, float32(multiplier))
-	outputMono := atkinsonDither.Monochrome(updateImg, float32(multiplier))
-	draw.Draw(dst, newBounds, outputMono, image.ZP, draw.Src)
 }
 
 func PixelsToPoints(pixels, dpi float64) float64 {
@@ -553,11 +705,11 @@ func PointsToPixels(points, dpi float64) float64 {
 func Int26_6ToFloat64(x fixed.Int26_6) float64 {
 	const shift, mask = 6, 1<<6 - 1
 	if x >= 0 {
-		return float64(x>>shift) + float64(x&mask)/72
+		return float64(x>>shift) + float64(x&mask)/64
 	}
 	x = -x
 	if x >= 0 {
-		return float64(x>>shift) + float64(x&mask)/72
+		return -(float64(x>>shift) + float64(x&mask)/64)
 	}
 	return math.MaxFloat64
 }
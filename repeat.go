@@ -0,0 +1,136 @@
+package epd
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// NodeTypeList is a node that repeats its children for each
+// item of the content with its id
+const NodeTypeList = "list"
+
+// defaultMoreText is the overflow line of a repeater that
+// has more items than its maxItems
+const defaultMoreText = "{{ .more }} more…"
+
+// repeatKey is the content a node repeats its children over,
+// its repeat key, or its id if it's a list. It's empty for
+// nodes that don't repeat.
+func (n *Node) repeatKey() string {
+	if n.Repeat != "" {
+		return n.Repeat
+	}
+	if n.Type == NodeTypeList {
+		return n.ID
+	}
+	return ""
+}
+
+// expandRepeat replaces the children of a repeater with a copy
+// of them for each item of its content. Each copy's expressions
+// are evaluated against its item, along with the item's index
+// as `.index` and the whole content as `.root`. Items over
+// maxItems are left out and the node's "more" line added.
+func expandRepeat(node *Node, path string, content RenderContent) (err error) {
+
+	key := node.repeatKey()
+	items, err := repeatItems(content[key])
+	if err != nil {
+		return &ContentError{ID: key, Err: err}
+	}
+
+	shown := len(items)
+	if node.MaxItems > 0 && node.MaxItems < shown {
+		shown = node.MaxItems
+	}
+
+	templates := node.Children
+	node.Children = nil
+	for idx, item := range items[:shown] {
+		if _, ok := item["index"]; !ok {
+			item["index"] = idx
+		}
+		if _, ok := item["root"]; !ok {
+			item["root"] = content
+		}
+		for childIdx, tpl := range templates {
+			child := tpl.clone()
+			if child.ID != "" {
+				child.ID = fmt.Sprintf("%s-%d", child.ID, idx)
+			}
+			show, errr := interpolateNode(child, fmt.Sprintf("%s.children[%d]", path, childIdx), item)
+			if errr != nil {
+				return errr
+			}
+			if show {
+				node.Children = append(node.Children, child)
+			}
+		}
+	}
+
+	if shown == len(items) {
+		return
+	}
+
+	more := node.More
+	if more == nil {
+		defaultMore := DefaultNode()
+		defaultMore.Type = "text"
+		defaultMore.Content = defaultMoreText
+		more = &defaultMore
+	}
+	more = more.clone()
+	show, err := interpolateNode(more, path+".more", RenderContent{
+		"more":  len(items) - shown,
+		"total": len(items),
+		"root":  content,
+	})
+	if err == nil && show {
+		node.Children = append(node.Children, more)
+	}
+	return
+}
+
+// repeatItems converts content to the items of a repeater. It can
+// be any slice, usually of maps as decoded from json. Items that
+// aren't maps are available to expressions as `.value`.
+// Missing content has no items.
+func repeatItems(content interface{}) (items []RenderContent, err error) {
+	if content == nil {
+		return
+	}
+	v := reflect.ValueOf(content)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list but got %T", content)
+	}
+	for idx := 0; idx < v.Len(); idx++ {
+		item := RenderContent{}
+		elem := reflect.Indirect(v.Index(idx))
+		if elem.Kind() == reflect.Interface {
+			elem = reflect.Indirect(elem.Elem())
+		}
+		if elem.Kind() == reflect.Map && elem.Type().Key().Kind() == reflect.String {
+			iter := elem.MapRange()
+			for iter.Next() {
+				item[iter.Key().String()] = iter.Value().Interface()
+			}
+		} else if elem.IsValid() {
+			item["value"] = elem.Interface()
+		}
+		items = append(items, item)
+	}
+	return
+}
+
+// clone returns a deep copy of the node and its children
+func (n *Node) clone() *Node {
+	c := *n
+	c.Children = nil
+	for _, child := range n.Children {
+		c.Children = append(c.Children, child.clone())
+	}
+	if n.More != nil {
+		c.More = n.More.clone()
+	}
+	return &c
+}
@@ -0,0 +1,148 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+// shapeCanvas rasterizes vector shapes into a mask, which is then
+// painted onto an image a single colour. Pixels are painted if
+// they're at least half covered, without anti-aliasing, so the
+// shapes come out crisp on a 1-bit panel.
+type shapeCanvas struct {
+	bounds image.Rectangle
+	mask   *image.Alpha
+	z      *vector.Rasterizer
+}
+
+// newShapeCanvas returns a canvas covering bounds. Shapes are
+// drawn in coordinates relative to the top left of bounds.
+func newShapeCanvas(bounds image.Rectangle) *shapeCanvas {
+	size := bounds.Size()
+	return &shapeCanvas{
+		bounds: bounds,
+		mask:   image.NewAlpha(image.Rectangle{Max: size}),
+		z:      vector.NewRasterizer(size.X, size.Y),
+	}
+}
+
+// fill adds the shape traced by path to the mask
+func (c *shapeCanvas) fill(path func(z *vector.Rasterizer)) {
+	size := c.bounds.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		return
+	}
+	c.z.Reset(size.X, size.Y)
+	path(c.z)
+	c.z.ClosePath()
+	c.z.Draw(c.mask, c.mask.Bounds(), image.Opaque, image.Point{})
+}
+
+// polygon adds a closed polygon of points
+func (c *shapeCanvas) polygon(points ...[2]float64) {
+	if len(points) < 3 {
+		return
+	}
+	c.fill(func(z *vector.Rasterizer) {
+		z.MoveTo(float32(points[0][0]), float32(points[0][1]))
+		for _, p := range points[1:] {
+			z.LineTo(float32(p[0]), float32(p[1]))
+		}
+	})
+}
+
+// rect adds a rectangle
+func (c *shapeCanvas) rect(x0, y0, x1, y1 float64) {
+	c.polygon([2]float64{x0, y0}, [2]float64{x1, y0}, [2]float64{x1, y1}, [2]float64{x0, y1})
+}
+
+// line adds a straight line width pixels wide, with square ends
+func (c *shapeCanvas) line(x0, y0, x1, y1, width float64) {
+	dx, dy := x1-x0, y1-y0
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		c.rect(x0-width/2, y0-width/2, x0+width/2, y0+width/2)
+		return
+	}
+	// Half width steps along and across the line
+	ax, ay := dx/length*width/2, dy/length*width/2
+	nx, ny := -ay, ax
+	c.polygon(
+		[2]float64{x0 - ax + nx, y0 - ay + ny},
+		[2]float64{x1 + ax + nx, y1 + ay + ny},
+		[2]float64{x1 + ax - nx, y1 + ay - ny},
+		[2]float64{x0 - ax - nx, y0 - ay - ny},
+	)
+}
+
+// polyline adds lines joining points
+func (c *shapeCanvas) polyline(points [][2]float64, width float64) {
+	for idx := 1; idx < len(points); idx++ {
+		c.line(points[idx-1][0], points[idx-1][1], points[idx][0], points[idx][1], width)
+	}
+	if len(points) == 1 {
+		c.line(points[0][0], points[0][1], points[0][0], points[0][1], width)
+	}
+}
+
+// dashed adds a dashed line of dashes dash pixels long
+func (c *shapeCanvas) dashed(x0, y0, x1, y1, width, dash float64) {
+	length := math.Hypot(x1-x0, y1-y0)
+	for start := 0.0; start < length; start += dash * 2 {
+		end := math.Min(start+dash, length)
+		c.line(
+			x0+(x1-x0)*start/length, y0+(y1-y0)*start/length,
+			x0+(x1-x0)*end/length, y0+(y1-y0)*end/length,
+			width,
+		)
+	}
+}
+
+// circle adds a filled circle
+func (c *shapeCanvas) circle(cx, cy, radius float64) {
+	c.arc(cx, cy, radius, 0, 0, 2*math.Pi)
+}
+
+// arc adds a ring segment between radii inner and outer, from
+// angle start to end in radians clockwise from 3 o'clock. An inner
+// radius of 0 makes a pie slice.
+func (c *shapeCanvas) arc(cx, cy, outer, inner, start, end float64) {
+	steps := int(math.Max(8, math.Ceil(math.Abs(end-start)*outer/2)))
+	var points [][2]float64
+	for i := 0; i <= steps; i++ {
+		angle := start + (end-start)*float64(i)/float64(steps)
+		points = append(points, [2]float64{cx + outer*math.Cos(angle), cy + outer*math.Sin(angle)})
+	}
+	if inner <= 0 {
+		points = append(points, [2]float64{cx, cy})
+	} else {
+		for i := steps; i >= 0; i-- {
+			angle := start + (end-start)*float64(i)/float64(steps)
+			points = append(points, [2]float64{cx + inner*math.Cos(angle), cy + inner*math.Sin(angle)})
+		}
+	}
+	c.polygon(points...)
+}
+
+// paint sets the pixels of dst covered by the shapes to col,
+// and clears the canvas
+func (c *shapeCanvas) paint(dst *image.RGBA, col color.Color) {
+	rgba := color.RGBAModel.Convert(col).(color.RGBA)
+	size := c.bounds.Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if c.mask.AlphaAt(x, y).A >= 0x80 {
+				p := c.bounds.Min.Add(image.Pt(x, y))
+				if p.In(dst.Rect) {
+					dst.SetRGBA(p.X, p.Y, rgba)
+				}
+			}
+		}
+	}
+	for i := range c.mask.Pix {
+		c.mask.Pix[i] = 0
+	}
+}
@@ -1,9 +1,11 @@
 package epd
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"sync"
 	"time"
 
 	"github.com/disintegration/imaging"
@@ -88,6 +90,8 @@ func Epd42(orientation Orientation, spiAddress, reset, dc, busy string, renderOp
 		height:       height,
 		orientation:  orientation,
 		driver:       SpiGpioDriver(),
+		stats:        newStatsTracker(panelName("epd42", spiAddress)),
+		mu:           &sync.Mutex{},
 	}
 
 	sepd := smallEpd{
@@ -108,7 +112,7 @@ func (display smallEpd) init() (err error) {
 
 	err = display.driver.Init(display.SPIAddress, display.RESET, display.DC, display.BUSY)
 	if err != nil {
-		err = fmt.Errorf("Error initialising driver. %s", err)
+		err = &DriverError{Op: "init", Err: fmt.Errorf("Error initialising driver. %s", err)}
 		return
 	}
 
@@ -116,12 +120,12 @@ func (display smallEpd) init() (err error) {
 		err = fmt.Errorf("Error setting up RESET pin: %s", err.Error())
 	} else if err = display.driver.Pin(display.DC).Out(gpio.Low); err != nil {
 		err = fmt.Errorf("Error setting up DC pin: %s", err.Error())
-	} else if err := display.driver.Pin(display.BUSY).In(gpio.PullDown, gpio.NoEdge); err != nil {
+	} else if err = display.driver.Pin(display.BUSY).In(gpio.PullDown, gpio.NoEdge); err != nil {
 		err = fmt.Errorf("Error setting up BUSY pin: %s", err.Error())
 	}
 
 	if err != nil {
-		err = fmt.Errorf("Could not set up display: %s", err.Error())
+		err = &DriverError{Op: "init", Err: fmt.Errorf("Could not set up display: %s", err.Error())}
 		display.epd.driver.Close()
 	}
 
@@ -143,6 +147,25 @@ func (display smallEpd) Show(content RenderContent) (err error) {
 
 func (display smallEpd) ShowWithTemplate(content RenderContent, tpl RenderTemplate) (err error) {
 
+	frame, err := display.RenderFrame(content, tpl)
+	if err != nil {
+		return
+	}
+
+	return display.ShowFrame(frame)
+}
+
+// DefaultTemplate returns the template Show uses
+func (display smallEpd) DefaultTemplate() RenderTemplate {
+	return display.RendererOpts.Template
+}
+
+// RenderFrame renders the content and converts it to the
+// panel's black and red buffers. It doesn't touch the panel
+// so can be called while the panel is busy. Template and
+// content errors are returned from the renderer as they are.
+func (display smallEpd) RenderFrame(content RenderContent, tpl RenderTemplate) (frame Frame, err error) {
+
 	var width, height int
 
 	if display.orientation == Landscape {
@@ -155,12 +178,41 @@ func (display smallEpd) ShowWithTemplate(content RenderContent, tpl RenderTempla
 
 	opts := display.RendererOpts
 	img, err := opts.Renderer.Render(content, width, height, tpl)
+	if err != nil {
+		return
+	}
+	if img == nil {
+		err = errors.New("Renderer returned no image")
+		return
+	}
+
+	displayHorizontal := display.Width() >= display.Height()
+	imageHorizontal := img.Bounds().Dx() >= img.Bounds().Dy()
+	if displayHorizontal != imageHorizontal {
+		// Rotate image 90
+		img = imaging.Rotate90(img)
+	}
+	if img.Bounds().Dx() != display.Width() || img.Bounds().Dy() != display.Height() {
+		img = imaging.Resize(img, display.Width(), display.Height(), imaging.Lanczos)
+	}
+	frame.Black, frame.Red = display.convertImage(img)
+
+	return
+}
+
+// ShowFrame wakes the panel, sends it the frame and puts it
+// back to sleep once the refresh has finished.
+func (display smallEpd) ShowFrame(frame Frame) (err error) {
+
+	display.mu.Lock()
+	defer display.mu.Unlock()
+	defer display.stats.flush()
 
 	if err = display.prepare(); err != nil {
 		return
 	}
 
-	if err = display.show(img); err != nil {
+	if err = display.show(frame); err != nil {
 		return
 	}
 
@@ -214,26 +266,16 @@ func (display smallEpd) prepare() (err error) {
 	return
 }
 
-// Display pushes the provided buffers to display
-// imageBlack is the black pixel buffer, imageRed is the red pixel buffer
-func (display smallEpd) show(img image.Image) (err error) {
+// Show pushes the frame's buffers to display
+// Black is the black pixel buffer, Red is the red pixel buffer
+func (display smallEpd) show(frame Frame) (err error) {
 	log.Debug("EPD42 Show")
-	displayHorizontal := display.Width() >= display.Height()
-	imageHorizontal := img.Bounds().Dx() >= img.Bounds().Dy()
-	if displayHorizontal != imageHorizontal {
-		// Rotate image 90
-		img = imaging.Rotate90(img)
-	}
-	if img.Bounds().Dx() != display.Width() || img.Bounds().Dy() != display.Height() {
-		img = imaging.Resize(img, display.Width(), display.Height(), imaging.Lanczos)
-	}
-	imageblack, imagered := display.convertImage(img)
 
 	if err = display.sendCommand(DATA_START_TRANSMISSION_1); err != nil {
 		return
 	}
 
-	if err = display.sendData(imageblack); err != nil {
+	if err = display.sendData(frame.Black); err != nil {
 		return
 	}
 
@@ -241,13 +283,14 @@ func (display smallEpd) show(img image.Image) (err error) {
 		return
 	}
 
-	if err = display.sendData(imagered); err != nil {
+	if err = display.sendData(frame.Red); err != nil {
 		return
 	}
 
 	if err = display.sendCommand(DISPLAY_REFRESH); err != nil {
 		return
 	}
+	display.stats.fullRefresh()
 
 	if err = display.waitUntilIdle(); err != nil {
 		return
@@ -261,6 +304,10 @@ func (display smallEpd) show(img image.Image) (err error) {
 func (display smallEpd) Clear() (err error) {
 	log.Debug("EPD42 Clear")
 
+	display.mu.Lock()
+	defer display.mu.Unlock()
+	defer display.stats.flush()
+
 	if err = display.sendCommand(DATA_START_TRANSMISSION_1); err != nil {
 		return
 	}
@@ -285,6 +332,7 @@ func (display smallEpd) Clear() (err error) {
 	if err = display.sendCommand(DISPLAY_REFRESH); err != nil {
 		return
 	}
+	display.stats.clear()
 
 	if err = display.waitUntilIdle(); err != nil {
 		return
@@ -330,20 +378,12 @@ func (display smallEpd) sleep() (err error) {
 func (display smallEpd) reset() (err error) {
 	log.Debug("EPD42 Reset")
 
-	if err = display.driver.DigitalWrite(display.RESET, gpio.High); err != nil {
-		return
-	}
-	time.Sleep(200 * time.Millisecond)
-
-	if err = display.driver.DigitalWrite(display.RESET, gpio.Low); err != nil {
-		return
-	}
-	time.Sleep(200 * time.Millisecond)
-
-	if err = display.driver.DigitalWrite(display.RESET, gpio.High); err != nil {
-		return
+	for _, level := range []gpio.Level{gpio.High, gpio.Low, gpio.High} {
+		if err = display.driver.DigitalWrite(display.RESET, level); err != nil {
+			return &DriverError{Op: "reset", Err: err}
+		}
+		time.Sleep(200 * time.Millisecond)
 	}
-	time.Sleep(200 * time.Millisecond)
 
 	log.Debug("EPD42 Reset End")
 	return
@@ -354,20 +394,8 @@ func (display smallEpd) reset() (err error) {
 func (display smallEpd) sendCommand(command Command) (err error) {
 	log.Debug("EPD42 SendCommand")
 
-	if err = display.driver.DigitalWrite(display.driver.CS(), gpio.Low); err != nil {
-		return
-	}
-
-	if err = display.driver.DigitalWrite(display.DC, gpio.Low); err != nil {
-		return
-	}
-
-	if err = display.driver.Write(command); err != nil {
-		return
-	}
-
-	if err = display.driver.DigitalWrite(display.driver.CS(), gpio.High); err != nil {
-		return
+	if err = display.transfer(gpio.Low, command); err != nil {
+		return &DriverError{Op: fmt.Sprintf("send command 0x%02X", []byte(command)), Err: err}
 	}
 
 	log.Debug("EPD42 SendCommand End")
@@ -377,38 +405,49 @@ func (display smallEpd) sendCommand(command Command) (err error) {
 // SendData writes data to the SPI connection of the device
 func (display smallEpd) sendData(data []byte) (err error) {
 	log.Debug("EPD42 SendData")
-	if err = display.driver.DigitalWrite(display.driver.CS(), gpio.Low); err != nil {
-		return
+
+	if err = display.transfer(gpio.High, data); err != nil {
+		return &DriverError{Op: "send data", Err: err}
 	}
 
-	if err = display.driver.DigitalWrite(display.DC, gpio.High); err != nil {
+	log.Debug("EPD42 SendData End")
+	return
+}
+
+// transfer selects the device, sets DC low for commands or
+// high for data, and writes the bytes
+func (display smallEpd) transfer(dc gpio.Level, data []byte) (err error) {
+
+	if err = display.driver.DigitalWrite(display.driver.CS(), gpio.Low); err != nil {
 		return
 	}
 
-	if err = display.driver.Write(data); err != nil {
+	if err = display.driver.DigitalWrite(display.DC, dc); err != nil {
 		return
 	}
 
-	if err = display.driver.DigitalWrite(display.driver.CS(), gpio.High); err != nil {
+	if err = display.driver.Write(data); err != nil {
 		return
 	}
 
-	log.Debug("EPD42 SendData End")
-	return
+	return display.driver.DigitalWrite(display.driver.CS(), gpio.High)
 }
 
 // WaitUntilIdle blocks until the device becomes available
 func (display smallEpd) waitUntilIdle() (err error) {
 	log.Debug("EPD42 WaitUntilIdle")
+	start := time.Now()
+	defer func() {
+		display.stats.busy(time.Since(start))
+	}()
 	for {
 		busy, err := display.driver.DigitalRead(display.BUSY)
+		if err != nil {
+			return &DriverError{Op: "read busy", Err: err}
+		}
 		if !busy {
 			break
 		}
-		if err != nil {
-			fmt.Printf("Error checking bust %s\n", err.Error())
-		}
-		fmt.Printf(".")
 		time.Sleep(200 * time.Millisecond)
 	}
 	log.Debug("EPD42 WaitUntilIdle End")
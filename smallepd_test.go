@@ -0,0 +1,23 @@
+package epd
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestShowRejectsBadTemplates checks bad templates and content are
+// rejected while rendering, before the panel is woken. The display
+// has no driver, so the test would panic if it were woken.
+func TestShowRejectsBadTemplates(t *testing.T) {
+	display := goldenDisplay(Landscape)
+
+	var tplErr *TemplateError
+	if err := display.ShowWithTemplate(RenderContent{}, `{"children": [`); !errors.As(err, &tplErr) {
+		t.Errorf("Expected a template error, got %v", err)
+	}
+	var contentErr *ContentError
+	tpl := RenderTemplate(`{"children": [{"id": "sku", "type": "barcode", "symbology": "ean13"}]}`)
+	if err := display.ShowWithTemplate(RenderContent{"sku": "not digits"}, tpl); !errors.As(err, &contentErr) || contentErr.ID != "sku" {
+		t.Errorf("Expected a content error for sku, got %v", err)
+	}
+}
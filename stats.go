@@ -0,0 +1,252 @@
+package epd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRatedRefreshes is the number of refreshes an e-paper
+// panel is expected to survive. Waveshare quote roughly a
+// million refreshes for their panels, so this is used unless
+// a panel specifies otherwise.
+const DefaultRatedRefreshes int64 = 1000000
+
+// PanelStats records how hard a single panel has been worked
+// over its lifetime. Every refresh of the panel, full or partial,
+// and every clear, wears the panel a little.
+// PartialRefreshes are only counted by drivers for panels that
+// support partial refresh. LastRefresh is nil until the panel
+// is first refreshed.
+type PanelStats struct {
+	Panel            string        `json:"panel"`
+	FullRefreshes    int64         `json:"fullRefreshes"`
+	PartialRefreshes int64         `json:"partialRefreshes"`
+	Clears           int64         `json:"clears"`
+	BusyTime         time.Duration `json:"busyTime"`
+	LongestBusy      time.Duration `json:"longestBusy"`
+	RatedRefreshes   int64         `json:"ratedRefreshes"`
+	FirstSeen        time.Time     `json:"firstSeen"`
+	LastRefresh      *time.Time    `json:"lastRefresh,omitempty"`
+}
+
+// Refreshes returns the total number of times the panel has
+// been driven. Clears count as they drive every pixel.
+func (s PanelStats) Refreshes() int64 {
+	return s.FullRefreshes + s.PartialRefreshes + s.Clears
+}
+
+// Wear returns the fraction of the panel's rated refreshes that
+// have been used up. 1 or more means the panel is past its
+// rated life.
+func (s PanelStats) Wear() float64 {
+	rated := s.RatedRefreshes
+	if rated <= 0 {
+		rated = DefaultRatedRefreshes
+	}
+	return float64(s.Refreshes()) / float64(rated)
+}
+
+// MarshalJSON adds the derived refresh total and wear fraction
+// so that consumers of the stats file don't need to work them out.
+func (s PanelStats) MarshalJSON() ([]byte, error) {
+	type Alias PanelStats
+	return json.Marshal(struct {
+		Alias
+		Refreshes int64   `json:"refreshes"`
+		Wear      float64 `json:"wear"`
+	}{
+		Alias:     Alias(s),
+		Refreshes: s.Refreshes(),
+		Wear:      s.Wear(),
+	})
+}
+
+// refreshed notes that the panel was just refreshed
+func (s *PanelStats) refreshed() {
+	now := time.Now()
+	s.LastRefresh = &now
+}
+
+// StatsReporter is implemented by displays that keep
+// refresh accounting for their panel.
+type StatsReporter interface {
+	// Stats returns a snapshot of the panel's statistics.
+	Stats() PanelStats
+	// SetStatsFile sets the json file stats are persisted to. Any
+	// stats already in the file for this panel are loaded and
+	// added to. A single file can be shared by several panels.
+	SetStatsFile(path string) (err error)
+}
+
+// statsTracker counts panel usage, and persists it to a
+// json file if one has been set. Counts are kept in memory
+// and written by flush, once per refresh, to spare the flash
+// storage of boards like the Pi. It is shared between
+// copies of a display so is always used by pointer.
+type statsTracker struct {
+	mu    sync.Mutex
+	stats PanelStats
+	path  string
+	dirty bool
+}
+
+func newStatsTracker(panel string) *statsTracker {
+	return &statsTracker{
+		stats: PanelStats{
+			Panel:          panel,
+			RatedRefreshes: DefaultRatedRefreshes,
+			FirstSeen:      time.Now(),
+		},
+	}
+}
+
+func (t *statsTracker) snapshot() PanelStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+func (t *statsTracker) setFile(path string) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	all, err := ReadStatsFile(path)
+	if err != nil {
+		return
+	}
+
+	if saved, ok := all[t.stats.Panel]; ok {
+		saved.FullRefreshes += t.stats.FullRefreshes
+		saved.PartialRefreshes += t.stats.PartialRefreshes
+		saved.Clears += t.stats.Clears
+		saved.BusyTime += t.stats.BusyTime
+		if t.stats.LongestBusy > saved.LongestBusy {
+			saved.LongestBusy = t.stats.LongestBusy
+		}
+		if t.stats.LastRefresh != nil && (saved.LastRefresh == nil || t.stats.LastRefresh.After(*saved.LastRefresh)) {
+			saved.LastRefresh = t.stats.LastRefresh
+		}
+		if saved.RatedRefreshes <= 0 {
+			saved.RatedRefreshes = t.stats.RatedRefreshes
+		}
+		t.stats = saved
+	}
+
+	t.path = path
+	if err = t.save(); err == nil {
+		t.dirty = false
+	}
+	return
+}
+
+func (t *statsTracker) fullRefresh() {
+	t.record(func(s *PanelStats) {
+		s.FullRefreshes++
+		s.refreshed()
+	})
+}
+
+func (t *statsTracker) clear() {
+	t.record(func(s *PanelStats) {
+		s.Clears++
+		s.refreshed()
+	})
+}
+
+func (t *statsTracker) busy(d time.Duration) {
+	t.record(func(s *PanelStats) {
+		s.BusyTime += d
+		if d > s.LongestBusy {
+			s.LongestBusy = d
+		}
+	})
+}
+
+func (t *statsTracker) record(update func(s *PanelStats)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	update(&t.stats)
+	t.dirty = true
+}
+
+// flush saves the stats recorded since the last flush
+func (t *statsTracker) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty || t.path == "" {
+		return
+	}
+	if err := t.save(); err != nil {
+		log.Warnf("Could not save panel stats to %s: %s", t.path, err.Error())
+		return
+	}
+	t.dirty = false
+}
+
+// save writes the stats for this panel into the stats file
+// leaving the stats of any other panels in it untouched.
+// Must be called with the lock held.
+func (t *statsTracker) save() (err error) {
+	if t.path == "" {
+		return
+	}
+
+	all, err := ReadStatsFile(t.path)
+	if err != nil {
+		return
+	}
+	all[t.stats.Panel] = t.stats
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return
+	}
+
+	// Write to a temp file and rename so a crash mid-write
+	// can't lose the panel history.
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), ".epdstats")
+	if err != nil {
+		return
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	// Sync before the rename, or a power cut can leave
+	// the renamed file empty
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// ReadStatsFile reads all panel stats from a stats file
+// keyed by panel name.
+func ReadStatsFile(path string) (stats map[string]PanelStats, err error) {
+	stats = make(map[string]PanelStats)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	err = json.Unmarshal(data, &stats)
+	return
+}
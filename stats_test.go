@@ -0,0 +1,104 @@
+package epd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epdstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+
+	lastRefresh := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	err = ioutil.WriteFile(path, []byte(`{
+		"epd7in5": {"panel": "epd7in5", "fullRefreshes": 10, "clears": 2, "busyTime": 5000, "longestBusy": 900,
+			"ratedRefreshes": 500, "lastRefresh": "2020-04-01T12:00:00Z"},
+		"epd2in13": {"panel": "epd2in13", "fullRefreshes": 7}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Counts from before the file was set are added to the saved ones
+	stats := newStatsTracker("epd7in5")
+	stats.fullRefresh()
+	stats.busy(1000)
+	if err = stats.setFile(path); err != nil {
+		t.Fatal(err)
+	}
+	s := stats.snapshot()
+	if s.FullRefreshes != 11 || s.Clears != 2 || s.BusyTime != 6000 || s.LongestBusy != 1000 || s.RatedRefreshes != 500 {
+		t.Errorf("Unexpected merged stats %+v", s)
+	}
+	if s.LastRefresh == nil || !s.LastRefresh.After(lastRefresh) {
+		t.Errorf("Expected the latest refresh, got %v", s.LastRefresh)
+	}
+
+	// Stats are only written when flushed
+	stats.clear()
+	if all, err := ReadStatsFile(path); err != nil || all["epd7in5"].Clears != 2 {
+		t.Errorf("Expected the clear to not be saved before a flush, got %+v, %v", all["epd7in5"], err)
+	}
+	stats.flush()
+
+	all, err := ReadStatsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := all["epd7in5"]; s.FullRefreshes != 11 || s.Clears != 3 || s.Refreshes() != 14 || s.Wear() != 14.0/500 {
+		t.Errorf("Unexpected saved stats %+v", s)
+	}
+	if s := all["epd2in13"]; s.FullRefreshes != 7 || s.LastRefresh != nil {
+		t.Errorf("Expected the other panel untouched, got %+v", s)
+	}
+
+	// The stats are swapped in whole, leaving no temp files behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the stats file, got %d files", len(files))
+	}
+
+	// A panel that has never been refreshed has no last refresh
+	fresh := newStatsTracker("epd4in2")
+	if err = fresh.setFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), `"lastRefresh"`) != 1 {
+		t.Errorf("Expected only epd7in5 to have a last refresh, got %s", data)
+	}
+}
+
+func TestStatsFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epdstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+	if err = ioutil.WriteFile(path, []byte(`{"epd7in5": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken file isn't overwritten, so the history in it can be recovered
+	if err = newStatsTracker("epd7in5").setFile(path); err == nil {
+		t.Error("Expected an error for an invalid stats file")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != `{"epd7in5": ` {
+		t.Errorf("Expected the stats file to be left alone, got %s", data)
+	}
+}
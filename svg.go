@@ -0,0 +1,724 @@
+package epd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+// NodeTypeSVG is a node that draws its content, the source
+// of an svg image, as crisp vector shapes
+const NodeTypeSVG = "svg"
+
+// curveSegments is the number of lines a bezier curve is
+// flattened into
+const curveSegments = 16
+
+// svgImage is a parsed svg, its shapes flattened into lines in
+// the coordinates of its view box
+type svgImage struct {
+	// viewBox is the area of the svg's coordinates that
+	// is drawn into a node
+	viewBox [4]float64
+	// width and height are the natural size of the image
+	width, height float64
+	shapes        []svgShape
+}
+
+// svgShape is a filled or stroked path of the image
+type svgShape struct {
+	// subpaths are the points of each part of the path
+	subpaths    [][][2]float64
+	closed      []bool
+	fill        string
+	stroke      string
+	strokeWidth float64
+}
+
+// svgStyle is the presentation svg elements inherit
+// from their parents
+type svgStyle struct {
+	fill        string
+	stroke      string
+	strokeWidth float64
+	transform   svgMatrix
+}
+
+// svgMatrix is an affine transform a, b, c, d, e, f as in
+// the svg matrix() transform
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+func (m svgMatrix) mul(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m svgMatrix) apply(x, y float64) [2]float64 {
+	return [2]float64{m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]}
+}
+
+// scale is how much the transform scales lengths, such as
+// stroke widths
+func (m svgMatrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// svgSkipped are elements that don't draw anything themselves
+var svgSkipped = map[string]bool{
+	"defs": true, "clipPath": true, "mask": true, "symbol": true, "title": true,
+	"desc": true, "style": true, "metadata": true, "pattern": true, "marker": true,
+}
+
+// parseSVG parses the paths and basic shapes of an svg image.
+// Gradients, text, clipping and masks aren't supported, and
+// are ignored.
+func parseSVG(source string) (img *svgImage, err error) {
+
+	decoder := xml.NewDecoder(strings.NewReader(source))
+	img = &svgImage{}
+	styles := []svgStyle{{fill: "black", stroke: "none", strokeWidth: 1, transform: svgIdentity}}
+	skip := 0
+	sawRoot := false
+
+	for {
+		token, errr := decoder.Token()
+		if errr == io.EOF {
+			break
+		}
+		if errr != nil {
+			return nil, fmt.Errorf("Invalid svg: %s", errr.Error())
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skip > 0 || svgSkipped[t.Name.Local] {
+				skip++
+				continue
+			}
+			attrs := svgAttrs(t.Attr)
+			style, errr := styles[len(styles)-1].inherit(attrs)
+			if errr != nil {
+				return nil, errr
+			}
+			styles = append(styles, style)
+			if t.Name.Local == "svg" && !sawRoot {
+				sawRoot = true
+				img.sizeFrom(attrs)
+				continue
+			}
+			var shape svgShape
+			if shape, errr = svgElement(t.Name.Local, attrs, style); errr != nil {
+				return nil, errr
+			}
+			if len(shape.subpaths) > 0 {
+				img.shapes = append(img.shapes, shape)
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(styles) > 1 {
+				styles = styles[:len(styles)-1]
+			}
+		}
+	}
+
+	if !sawRoot {
+		return nil, errors.New("Invalid svg: no svg element")
+	}
+	return
+}
+
+// svgAttrs maps an element's attributes by name, with
+// properties in its style attribute overriding them
+func svgAttrs(attrs []xml.Attr) map[string]string {
+	m := map[string]string{}
+	for _, attr := range attrs {
+		m[attr.Name.Local] = attr.Value
+	}
+	for _, property := range strings.Split(m["style"], ";") {
+		if parts := strings.SplitN(property, ":", 2); len(parts) == 2 {
+			m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return m
+}
+
+func (s svgStyle) inherit(attrs map[string]string) (style svgStyle, err error) {
+	style = s
+	if fill, ok := attrs["fill"]; ok {
+		style.fill = fill
+	}
+	if stroke, ok := attrs["stroke"]; ok {
+		style.stroke = stroke
+	}
+	if width, ok := attrs["stroke-width"]; ok {
+		style.strokeWidth = svgLength(width)
+	}
+	if transform, ok := attrs["transform"]; ok {
+		var m svgMatrix
+		if m, err = parseSVGTransform(transform); err != nil {
+			return
+		}
+		style.transform = style.transform.mul(m)
+	}
+	return
+}
+
+// sizeFrom sets the image's view box and natural size from the
+// attributes of its svg element. Either defaults to the other.
+func (img *svgImage) sizeFrom(attrs map[string]string) {
+	img.width, img.height = svgLength(attrs["width"]), svgLength(attrs["height"])
+	if nums := svgNumbers(attrs["viewBox"]); len(nums) == 4 && nums[2] > 0 && nums[3] > 0 {
+		copy(img.viewBox[:], nums)
+	} else {
+		img.viewBox = [4]float64{0, 0, img.width, img.height}
+	}
+	if img.width <= 0 {
+		img.width = img.viewBox[2]
+	}
+	if img.height <= 0 {
+		img.height = img.viewBox[3]
+	}
+}
+
+// svgLength parses a length in user units, ignoring px units.
+// Percentages and other units aren't supported and are 0.
+func svgLength(length string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(length), "px"), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// svgNumbers parses a list of numbers separated by
+// spaces or commas
+func svgNumbers(list string) (nums []float64) {
+	p := svgPathParser{data: list}
+	for {
+		n, ok := p.number()
+		if !ok {
+			return
+		}
+		nums = append(nums, n)
+	}
+}
+
+func parseSVGTransform(transform string) (m svgMatrix, err error) {
+	m = svgIdentity
+	for _, part := range strings.Split(transform, ")") {
+		part = strings.TrimLeft(strings.TrimSpace(part), ",")
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		open := strings.Index(part, "(")
+		if open < 0 {
+			return m, fmt.Errorf("Invalid svg transform %q", transform)
+		}
+		name, args := strings.TrimSpace(part[:open]), svgNumbers(part[open+1:])
+		arg := func(idx int, def float64) float64 {
+			if idx < len(args) {
+				return args[idx]
+			}
+			return def
+		}
+		var t svgMatrix
+		switch name {
+		case "matrix":
+			if len(args) != 6 {
+				return m, fmt.Errorf("Invalid svg transform %q", transform)
+			}
+			copy(t[:], args)
+		case "translate":
+			t = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			t = svgMatrix{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			a := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = svgMatrix{1, 0, 0, 1, cx, cy}.
+				mul(svgMatrix{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), 0, 0}).
+				mul(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			t = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("Unknown svg transform %s", name)
+		}
+		m = m.mul(t)
+	}
+	return
+}
+
+// svgElement converts a shape element to the points of its path
+func svgElement(name string, attrs map[string]string, style svgStyle) (shape svgShape, err error) {
+
+	num := func(key string) float64 {
+		return svgLength(attrs[key])
+	}
+	var path svgPath
+
+	switch name {
+	case "path":
+		if path, err = parseSVGPath(attrs["d"]); err != nil {
+			return
+		}
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		rx, ry := num("rx"), num("ry")
+		if rx == 0 {
+			rx = ry
+		}
+		if ry == 0 {
+			ry = rx
+		}
+		rx, ry = math.Min(rx, w/2), math.Min(ry, h/2)
+		if w <= 0 || h <= 0 {
+			return
+		}
+		path.moveTo(x+rx, y)
+		path.lineTo(x+w-rx, y)
+		path.arcTo(rx, ry, 0, false, true, x+w, y+ry)
+		path.lineTo(x+w, y+h-ry)
+		path.arcTo(rx, ry, 0, false, true, x+w-rx, y+h)
+		path.lineTo(x+rx, y+h)
+		path.arcTo(rx, ry, 0, false, true, x, y+h-ry)
+		path.lineTo(x, y+ry)
+		path.arcTo(rx, ry, 0, false, true, x+rx, y)
+		path.close()
+	case "circle", "ellipse":
+		cx, cy := num("cx"), num("cy")
+		rx, ry := num("rx"), num("ry")
+		if name == "circle" {
+			rx, ry = num("r"), num("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return
+		}
+		path.moveTo(cx+rx, cy)
+		path.arcTo(rx, ry, 0, false, true, cx-rx, cy)
+		path.arcTo(rx, ry, 0, false, true, cx+rx, cy)
+		path.close()
+	case "line":
+		path.moveTo(num("x1"), num("y1"))
+		path.lineTo(num("x2"), num("y2"))
+	case "polyline", "polygon":
+		nums := svgNumbers(attrs["points"])
+		for i := 0; i+1 < len(nums); i += 2 {
+			if i == 0 {
+				path.moveTo(nums[i], nums[i+1])
+			} else {
+				path.lineTo(nums[i], nums[i+1])
+			}
+		}
+		if name == "polygon" {
+			path.close()
+		}
+	default:
+		return
+	}
+
+	for idx, subpath := range path.subpaths {
+		if len(subpath) < 2 {
+			continue
+		}
+		points := make([][2]float64, len(subpath))
+		for i, p := range subpath {
+			points[i] = style.transform.apply(p[0], p[1])
+		}
+		shape.subpaths = append(shape.subpaths, points)
+		shape.closed = append(shape.closed, path.closed[idx])
+	}
+	shape.fill = style.fill
+	shape.stroke = style.stroke
+	shape.strokeWidth = style.strokeWidth * style.transform.scale()
+	return
+}
+
+// svgPath builds the flattened subpaths of a path
+type svgPath struct {
+	subpaths [][][2]float64
+	closed   []bool
+	// x, y is the current point
+	x, y float64
+}
+
+func (p *svgPath) moveTo(x, y float64) {
+	p.subpaths = append(p.subpaths, [][2]float64{{x, y}})
+	p.closed = append(p.closed, false)
+	p.x, p.y = x, y
+}
+
+func (p *svgPath) lineTo(x, y float64) {
+	if len(p.subpaths) == 0 {
+		p.moveTo(p.x, p.y)
+	}
+	last := len(p.subpaths) - 1
+	p.subpaths[last] = append(p.subpaths[last], [2]float64{x, y})
+	p.x, p.y = x, y
+}
+
+func (p *svgPath) close() {
+	if len(p.subpaths) == 0 {
+		return
+	}
+	last := len(p.subpaths) - 1
+	p.closed[last] = true
+	// The next segment starts where this subpath did
+	p.x, p.y = p.subpaths[last][0][0], p.subpaths[last][0][1]
+}
+
+func (p *svgPath) cubicTo(x1, y1, x2, y2, x, y float64) {
+	x0, y0 := p.x, p.y
+	for i := 1; i <= curveSegments; i++ {
+		t := float64(i) / curveSegments
+		u := 1 - t
+		p.lineTo(
+			u*u*u*x0+3*u*u*t*x1+3*u*t*t*x2+t*t*t*x,
+			u*u*u*y0+3*u*u*t*y1+3*u*t*t*y2+t*t*t*y,
+		)
+	}
+}
+
+func (p *svgPath) quadTo(x1, y1, x, y float64) {
+	x0, y0 := p.x, p.y
+	for i := 1; i <= curveSegments; i++ {
+		t := float64(i) / curveSegments
+		u := 1 - t
+		p.lineTo(u*u*x0+2*u*t*x1+t*t*x, u*u*y0+2*u*t*y1+t*t*y)
+	}
+}
+
+// arcTo adds an elliptical arc to x, y as in the svg arc command,
+// converting it to its centre as the svg spec describes
+func (p *svgPath) arcTo(rx, ry, rotation float64, large, sweep bool, x, y float64) {
+	x0, y0 := p.x, p.y
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || (x0 == x && y0 == y) {
+		p.lineTo(x, y)
+		return
+	}
+	phi := rotation * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+	dx, dy := (x0-x)/2, (y0-y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	// Scale up radii too small to reach the end point
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (x0+x)/2
+	cy := sin*cx1 + cos*cy1 + (y0+y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	start := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	steps := int(math.Ceil(math.Abs(delta) / (math.Pi / curveSegments)))
+	for i := 1; i <= steps; i++ {
+		a := start + delta*float64(i)/float64(steps)
+		ex, ey := rx*math.Cos(a), ry*math.Sin(a)
+		p.lineTo(cos*ex-sin*ey+cx, sin*ex+cos*ey+cy)
+	}
+	// Land exactly on the end point
+	p.x, p.y = x, y
+}
+
+// svgPathParser reads the commands and numbers of path data
+type svgPathParser struct {
+	data string
+	pos  int
+}
+
+func (p *svgPathParser) skipSeparators() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n,", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// number reads the next number, which may run straight on from
+// the last, as in "1-2" or "0.5.5"
+func (p *svgPathParser) number() (n float64, ok bool) {
+	p.skipSeparators()
+	start := p.pos
+	if p.pos < len(p.data) && (p.data[p.pos] == '-' || p.data[p.pos] == '+') {
+		p.pos++
+	}
+	dot, digits := false, false
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		p.pos++
+	}
+	if digits && p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '-' || p.data[p.pos] == '+') {
+			p.pos++
+		}
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	if !digits {
+		p.pos = start
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(p.data[start:p.pos], 64)
+	return n, err == nil
+}
+
+// flag reads an arc flag, which may be packed against the
+// next number as in "011"
+func (p *svgPathParser) flag() (set bool, ok bool) {
+	p.skipSeparators()
+	if p.pos < len(p.data) && (p.data[p.pos] == '0' || p.data[p.pos] == '1') {
+		p.pos++
+		return p.data[p.pos-1] == '1', true
+	}
+	return false, false
+}
+
+// parseSVGPath parses the d attribute of a path
+func parseSVGPath(d string) (path svgPath, err error) {
+
+	p := svgPathParser{data: d}
+	var command byte
+	// The control point of the last curve, for the smooth curve commands
+	var ctrlX, ctrlY float64
+	var lastCommand byte
+
+	for {
+		p.skipSeparators()
+		if p.pos >= len(p.data) {
+			return
+		}
+		start := p.pos
+		if c := p.data[p.pos]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			command = c
+			p.pos++
+		} else if command == 0 {
+			return path, fmt.Errorf("Invalid svg path at %d: %q", p.pos, d)
+		}
+
+		relative := command >= 'a'
+		var ox, oy float64
+		if relative {
+			ox, oy = path.x, path.y
+		}
+		var nums [7]float64
+		read := func(count int) bool {
+			for i := 0; i < count; i++ {
+				var ok bool
+				if nums[i], ok = p.number(); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		invalid := func() error {
+			return fmt.Errorf("Invalid svg path at %d: %q", p.pos, d)
+		}
+
+		upper := command &^ 0x20
+		switch upper {
+		case 'Z':
+			path.close()
+			// Z takes no numbers, so numbers after it need a
+			// command of their own
+			command = 0
+		case 'M':
+			if !read(2) {
+				return path, invalid()
+			}
+			path.moveTo(ox+nums[0], oy+nums[1])
+			// Further pairs are lines
+			if relative {
+				command = 'l'
+			} else {
+				command = 'L'
+			}
+		case 'L':
+			if !read(2) {
+				return path, invalid()
+			}
+			path.lineTo(ox+nums[0], oy+nums[1])
+		case 'H':
+			if !read(1) {
+				return path, invalid()
+			}
+			path.lineTo(ox+nums[0], path.y)
+		case 'V':
+			if !read(1) {
+				return path, invalid()
+			}
+			path.lineTo(path.x, oy+nums[0])
+		case 'C':
+			if !read(6) {
+				return path, invalid()
+			}
+			ctrlX, ctrlY = ox+nums[2], oy+nums[3]
+			path.cubicTo(ox+nums[0], oy+nums[1], ctrlX, ctrlY, ox+nums[4], oy+nums[5])
+		case 'S':
+			if !read(4) {
+				return path, invalid()
+			}
+			x1, y1 := path.x, path.y
+			if lastCommand == 'C' || lastCommand == 'S' {
+				x1, y1 = 2*path.x-ctrlX, 2*path.y-ctrlY
+			}
+			ctrlX, ctrlY = ox+nums[0], oy+nums[1]
+			path.cubicTo(x1, y1, ctrlX, ctrlY, ox+nums[2], oy+nums[3])
+		case 'Q':
+			if !read(4) {
+				return path, invalid()
+			}
+			ctrlX, ctrlY = ox+nums[0], oy+nums[1]
+			path.quadTo(ctrlX, ctrlY, ox+nums[2], oy+nums[3])
+		case 'T':
+			if !read(2) {
+				return path, invalid()
+			}
+			x1, y1 := path.x, path.y
+			if lastCommand == 'Q' || lastCommand == 'T' {
+				x1, y1 = 2*path.x-ctrlX, 2*path.y-ctrlY
+			}
+			ctrlX, ctrlY = x1, y1
+			path.quadTo(ctrlX, ctrlY, ox+nums[0], oy+nums[1])
+		case 'A':
+			if !read(3) {
+				return path, invalid()
+			}
+			rx, ry, rotation := nums[0], nums[1], nums[2]
+			large, ok1 := p.flag()
+			sweep, ok2 := p.flag()
+			if !ok1 || !ok2 || !read(2) {
+				return path, invalid()
+			}
+			path.arcTo(rx, ry, rotation, large, sweep, ox+nums[0], oy+nums[1])
+		}
+		lastCommand = upper
+		// Every command reads something, so a path can't loop
+		if p.pos == start {
+			return path, invalid()
+		}
+	}
+}
+
+// svgColor is the colour an svg paint is drawn in. currentColor is
+// the node's colour, and colours are snapped to the panel's. Other
+// colours the panel can't show, such as named colours, are black.
+func svgColor(paint string, current color.Color) color.Color {
+	switch strings.TrimSpace(paint) {
+	case "", "none", "transparent":
+		return nil
+	case "currentColor":
+		return current
+	}
+	if c := ColorFromString(paint); c != nil {
+		return c
+	}
+	return ColorBlack
+}
+
+// drawSVG draws an svg image into bounds, scaled to fit and placed
+// at the node's object position, snapping its colours to the panel
+// with no anti-aliasing or dithering.
+func (r flexRenderEngine) drawSVG(node *Node, img *svgImage, bounds image.Rectangle, dst *image.RGBA) {
+
+	viewWidth, viewHeight := img.viewBox[2], img.viewBox[3]
+	if bounds.Empty() || viewWidth <= 0 || viewHeight <= 0 {
+		return
+	}
+
+	scaleX, scaleY := float64(bounds.Dx())/viewWidth, float64(bounds.Dy())/viewHeight
+	if node.ObjectFit != ObjectFitFill {
+		scaleX = math.Min(scaleX, scaleY)
+		scaleY = scaleX
+	}
+	posX, posY := ObjectPositionFromString(node.ObjectPosition)
+	offsetX := (float64(bounds.Dx()) - viewWidth*scaleX) * posX
+	offsetY := (float64(bounds.Dy()) - viewHeight*scaleY) * posY
+	point := func(p [2]float64) [2]float64 {
+		return [2]float64{
+			(p[0]-img.viewBox[0])*scaleX + offsetX,
+			(p[1]-img.viewBox[1])*scaleY + offsetY,
+		}
+	}
+
+	current := ColorFromString(node.Color)
+	if current == nil {
+		current = ColorBlack
+	}
+
+	canvas := newShapeCanvas(bounds)
+	for _, shape := range img.shapes {
+		if fill := svgColor(shape.fill, current); fill != nil {
+			canvas.fill(func(z *vector.Rasterizer) {
+				for _, subpath := range shape.subpaths {
+					first := point(subpath[0])
+					z.MoveTo(float32(first[0]), float32(first[1]))
+					for _, p := range subpath[1:] {
+						p = point(p)
+						z.LineTo(float32(p[0]), float32(p[1]))
+					}
+					z.ClosePath()
+				}
+			})
+			canvas.paint(dst, fill)
+		}
+		if stroke := svgColor(shape.stroke, current); stroke != nil && shape.strokeWidth > 0 {
+			// Strokes are at least a pixel wide so they don't vanish
+			width := math.Max(1, shape.strokeWidth*math.Sqrt(scaleX*scaleY))
+			for idx, subpath := range shape.subpaths {
+				points := make([][2]float64, 0, len(subpath)+1)
+				for _, p := range subpath {
+					points = append(points, point(p))
+				}
+				if shape.closed[idx] {
+					points = append(points, points[0])
+				}
+				canvas.polyline(points, width)
+			}
+			canvas.paint(dst, stroke)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package epd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSVGPath(t *testing.T) {
+	tests := []struct {
+		d        string
+		subpaths int
+		valid    bool
+	}{
+		{"M0 0 L1 1 Z", 1, true},
+		{"M0 0 L1 1 Z M2 2 L3 3 z", 2, true},
+		{"M0,0 1,1 2,2", 1, true},
+		{"m0 0 h10 v10 h-10 z", 1, true},
+		{"M0 0 A5 5 0 1 0 10 10", 1, true},
+		// Numbers after a close need a command
+		{"M0 0 L1 1 Z 3", 1, false},
+		{"M0 0 L1 1 z 3 4", 1, false},
+		{"M0 0 L1", 1, false},
+		{"1 1", 0, false},
+		{"M0 0 #", 1, false},
+	}
+	for _, test := range tests {
+		done := make(chan struct{})
+		var path svgPath
+		var err error
+		go func() {
+			path, err = parseSVGPath(test.d)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Parsing %q didn't finish", test.d)
+		}
+		if test.valid && err != nil {
+			t.Errorf("Parsing %q: %s", test.d, err.Error())
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected parsing %q to fail", test.d)
+		}
+		if len(path.subpaths) != test.subpaths {
+			t.Errorf("Expected %d subpaths from %q, got %d", test.subpaths, test.d, len(path.subpaths))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package epd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// svgCanvas writes an SVG document. The document is built up
+// in body, so the fonts it uses can be embedded ahead of it.
+type svgCanvas struct {
+	fonts  *FontRegistry
+	width  int
+	height int
+	body   bytes.Buffer
+	clips  int
+	// embedded are the css names of embedded fonts, by font file
+	embedded map[*byte]string
+	// fontFaces are the @font-face rules of the embedded fonts
+	fontFaces []string
+}
+
+func newSVGCanvas(fonts *FontRegistry, width, height int) *svgCanvas {
+	return &svgCanvas{
+		fonts:    fonts,
+		width:    width,
+		height:   height,
+		embedded: make(map[*byte]string),
+	}
+}
+
+func (c *svgCanvas) writeTo(w io.Writer) (err error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", c.width, c.height, c.width, c.height)
+	if len(c.fontFaces) > 0 {
+		fmt.Fprintf(&b, "<style>\n%s</style>\n", strings.Join(c.fontFaces, ""))
+	}
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`+"\n", c.width, c.height)
+	b.Write(c.body.Bytes())
+	b.WriteString("</svg>\n")
+	_, err = w.Write(b.Bytes())
+	return
+}
+
+func (c *svgCanvas) rect(box roundedRect, col color.Color, width int) {
+	x, y := float64(box.rect.Min.X), float64(box.rect.Min.Y)
+	w, h := float64(box.rect.Dx()), float64(box.rect.Dy())
+	radius := box.radius
+	paint := fmt.Sprintf(`fill="%s"`, svgColorString(col))
+	if width > 0 {
+		// Strokes are centred on their path
+		inset := float64(width) / 2
+		x, y, w, h = x+inset, y+inset, w-2*inset, h-2*inset
+		radius = math.Max(0, radius-inset)
+		paint = fmt.Sprintf(`fill="none" stroke="%s" stroke-width="%d"`, svgColorString(col), width)
+	}
+	fmt.Fprintf(&c.body, `<rect x="%s" y="%s" width="%s" height="%s"%s %s/>`+"\n",
+		svgNumber(x), svgNumber(y), svgNumber(w), svgNumber(h), svgRadius(radius), paint)
+}
+
+func (c *svgCanvas) text(face textFace, col color.Color, x, y float64, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	family, embedded := c.fontFamily(face, text)
+	fmt.Fprintf(&c.body, `<text x="%s" y="%s" font-family="%s" font-size="%s"`,
+		svgNumber(x), svgNumber(y), family, svgNumber(face.size))
+	// Embedded fonts are already the weight and style they were
+	// picked for, so asking for them again would fake them twice
+	if !embedded && face.weight != 0 && face.weight != FontWeightNormal {
+		fmt.Fprintf(&c.body, ` font-weight="%d"`, face.weight)
+	}
+	if !embedded && face.style == FontStyleItalic {
+		c.body.WriteString(` font-style="italic"`)
+	}
+	if face.letterSpacing != 0 {
+		fmt.Fprintf(&c.body, ` letter-spacing="%s"`, svgNumber(face.letterSpacing))
+	}
+	fmt.Fprintf(&c.body, ` fill="%s" xml:space="preserve">`, svgColorString(col))
+	xml.EscapeText(&c.body, []byte(text))
+	c.body.WriteString("</text>\n")
+}
+
+func (c *svgCanvas) image(img image.Image, bounds image.Rectangle) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return
+	}
+	// Pixels are kept sharp when zoomed in, as on the panel
+	fmt.Fprintf(&c.body, `<image x="%d" y="%d" width="%d" height="%d" image-rendering="pixelated" href="data:image/png;base64,%s"/>`+"\n",
+		bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy(), base64.StdEncoding.EncodeToString(data.Bytes()))
+}
+
+func (c *svgCanvas) invert(box roundedRect) {
+	fmt.Fprintf(&c.body, `<rect x="%d" y="%d" width="%d" height="%d"%s fill="#ffffff" style="mix-blend-mode:difference"/>`+"\n",
+		box.rect.Min.X, box.rect.Min.Y, box.rect.Dx(), box.rect.Dy(), svgRadius(box.radius))
+}
+
+func (c *svgCanvas) clip(bounds image.Rectangle) {
+	c.clips++
+	fmt.Fprintf(&c.body, `<clipPath id="clip%d"><rect x="%d" y="%d" width="%d" height="%d"/></clipPath>`+"\n",
+		c.clips, bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy())
+	fmt.Fprintf(&c.body, `<g clip-path="url(#clip%d)">`+"\n", c.clips)
+}
+
+func (c *svgCanvas) unclip() {
+	c.body.WriteString("</g>\n")
+}
+
+// fontFamily is the css font family of text in a face. The font
+// it was laid out with is embedded if it can be, so the text is
+// drawn exactly where it would be on the panel. Otherwise the
+// family is named, for the viewer to find or stand in for.
+func (c *svgCanvas) fontFamily(face textFace, text string) (family string, embedded bool) {
+	generic := "sans-serif"
+	if strings.EqualFold(face.family, FontFamilyMono) {
+		generic = "monospace"
+	}
+	source := c.fonts.sourceFor(face.family, face.weight, face.style, text)
+	data, ok := embeddable(source)
+	if !ok {
+		if face.family == "" || strings.EqualFold(face.family, FontFamilyDefault) {
+			return generic, false
+		}
+		return fmt.Sprintf("'%s', %s", svgEscape(face.family), generic), false
+	}
+	name, ok := c.embedded[&data[0]]
+	if !ok {
+		name = fmt.Sprintf("font%d", len(c.embedded)+1)
+		c.embedded[&data[0]] = name
+		c.fontFaces = append(c.fontFaces, fmt.Sprintf("@font-face { font-family: %s; src: url(data:font/ttf;base64,%s); }\n",
+			name, base64.StdEncoding.EncodeToString(data)))
+	}
+	return name + ", " + generic, true
+}
+
+// svgColorString is a colour as a css hex colour
+func svgColorString(c color.Color) string {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return fmt.Sprintf("#%02x%02x%02x", rgba.R, rgba.G, rgba.B)
+}
+
+// svgNumber formats a coordinate to a hundredth of a pixel
+func svgNumber(f float64) string {
+	s := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", f), "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func svgRadius(radius float64) string {
+	if radius <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(` rx="%s"`, svgNumber(radius))
+}
+
+func svgEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return strings.Replace(b.String(), "'", "&#39;", -1)
+}
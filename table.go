@@ -0,0 +1,326 @@
+package epd
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NodeTypeTable is a node whose content is rows of cells,
+// laid out in columns
+const NodeTypeTable = "table"
+
+// TableColumn describes a column of a table node
+type TableColumn struct {
+	// Title is shown in the header row. The header is only
+	// shown if a column has a title.
+	Title string `json:"title"`
+	// Key is the field of each row shown in the column,
+	// for rows that are objects rather than lists of cells
+	Key string `json:"key"`
+	// Width is a number of pixels, a percentage of the table,
+	// a fraction of the space left over such as "1fr", or
+	// "auto" (default) to fit the column's widest cell.
+	Width string `json:"width"`
+	// Align is the text alignment of the column's cells
+	Align string `json:"align"`
+}
+
+type columnSizing int
+
+const (
+	columnAuto columnSizing = iota
+	columnFixed
+	columnFraction
+)
+
+// sizing parses the column's width into how it is sized and
+// its size in pixels or fractions
+func (c TableColumn) sizing(tableWidth float64) (sizing columnSizing, size float64) {
+	width := strings.TrimSpace(strings.ToLower(c.Width))
+	switch {
+	case strings.HasSuffix(width, "fr"):
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(width, "fr"), 64); err == nil && f > 0 {
+			return columnFraction, f
+		}
+	case strings.HasSuffix(width, "%"):
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(width, "%"), 64); err == nil && !math.IsInf(tableWidth, 1) {
+			return columnFixed, tableWidth * f / 100
+		}
+	default:
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(width, "px"), 64); err == nil {
+			return columnFixed, f
+		}
+	}
+	return columnAuto, 0
+}
+
+// tableRows converts table content to rows of cell text. Rows
+// can be lists of cells, or objects whose cells are picked out by
+// the keys of the columns.
+func tableRows(content interface{}, columns []TableColumn) (rows [][]string, err error) {
+	if content == nil {
+		return
+	}
+	if r, ok := content.([][]string); ok {
+		return r, nil
+	}
+	v := reflect.ValueOf(content)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected rows but got %T", content)
+	}
+	for idx := 0; idx < v.Len(); idx++ {
+		row := reflect.Indirect(v.Index(idx))
+		if row.Kind() == reflect.Interface {
+			row = reflect.Indirect(row.Elem())
+		}
+		var cells []string
+		switch row.Kind() {
+		case reflect.Slice, reflect.Array:
+			for cell := 0; cell < row.Len(); cell++ {
+				cells = append(cells, fmt.Sprint(row.Index(cell).Interface()))
+			}
+		case reflect.Map:
+			if row.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("row %d has keys of %s", idx, row.Type().Key())
+			}
+			for _, column := range columns {
+				cell := row.MapIndex(reflect.ValueOf(column.Key).Convert(row.Type().Key()))
+				if cell.IsValid() && !(cell.Kind() == reflect.Interface && cell.IsNil()) {
+					cells = append(cells, fmt.Sprint(cell.Interface()))
+				} else {
+					cells = append(cells, "")
+				}
+			}
+		default:
+			return nil, fmt.Errorf("row %d is a %s, not a list or object", idx, row.Kind())
+		}
+		rows = append(rows, cells)
+	}
+	return
+}
+
+type tableCell struct {
+	face   textFace
+	style  TextStyle
+	layout textLayout
+}
+
+type tableRow struct {
+	cells  []tableCell
+	height float64
+}
+
+// tableLayout is a table's rows laid out in its columns
+type tableLayout struct {
+	columns []float64
+	header  *tableRow
+	rows    []tableRow
+	rule    float64
+	width   float64
+	height  float64
+}
+
+// layoutTable lays out a table node's rows within width pixels.
+// A width of +Inf lays the table out at its natural width.
+func (r flexRenderEngine) layoutTable(node *Node, rows [][]string, width float64) (table tableLayout) {
+
+	columns := append([]TableColumn(nil), node.Columns...)
+	count := len(columns)
+	for _, row := range rows {
+		if len(row) > count {
+			count = len(row)
+		}
+	}
+	for len(columns) < count {
+		columns = append(columns, TableColumn{})
+	}
+
+	bodyStyle := node.TextStyle()
+	headerStyle := bodyStyle
+	headerStyle.FontWeight = FontWeightBold
+	if headerColor := ColorFromString(node.HeaderColor); headerColor != nil {
+		headerStyle.Color = headerColor
+	}
+	bodyFace := r.textFace(bodyStyle)
+	headerFace := r.textFace(headerStyle)
+
+	var titles []string
+	for _, column := range columns {
+		if column.Title != "" {
+			titles = make([]string, count)
+			for idx := range columns {
+				titles[idx] = columns[idx].Title
+			}
+			break
+		}
+	}
+
+	// Natural widths fit each column's widest cell on one line
+	pad := float64(node.CellPadding)
+	natural := make([]float64, count)
+	measure := func(face textFace, cells []string) {
+		for idx, cell := range cells {
+			for _, line := range strings.Split(cell, "\n") {
+				natural[idx] = math.Max(natural[idx], face.measure(line)+2*pad)
+			}
+		}
+	}
+	if titles != nil {
+		measure(headerFace, titles)
+	}
+	for _, row := range rows {
+		measure(bodyFace, row)
+	}
+
+	table.columns = make([]float64, count)
+	var fixed, flexible, fractions float64
+	for idx, column := range columns {
+		sizing, size := column.sizing(width)
+		switch sizing {
+		case columnFixed:
+			table.columns[idx] = size
+			fixed += size
+		case columnFraction:
+			fractions += size
+		default:
+			table.columns[idx] = natural[idx]
+			flexible += natural[idx]
+		}
+	}
+
+	if !math.IsInf(width, 1) {
+		if remaining := width - fixed - flexible; remaining >= 0 && fractions > 0 {
+			for idx, column := range columns {
+				if sizing, size := column.sizing(width); sizing == columnFraction {
+					table.columns[idx] = remaining * size / fractions
+				}
+			}
+		} else if remaining < 0 {
+			// Too wide, so auto and fraction columns share
+			// what's left in proportion to their natural widths
+			for idx, column := range columns {
+				if sizing, _ := column.sizing(width); sizing == columnFraction {
+					table.columns[idx] = natural[idx]
+					flexible += natural[idx]
+				}
+			}
+			scale := math.Max(0, width-fixed) / flexible
+			for idx, column := range columns {
+				if sizing, _ := column.sizing(width); sizing != columnFixed {
+					table.columns[idx] = table.columns[idx] * scale
+				}
+			}
+		}
+	} else {
+		for idx, column := range columns {
+			if sizing, _ := column.sizing(width); sizing == columnFraction {
+				table.columns[idx] = natural[idx]
+			}
+		}
+	}
+
+	layoutRow := func(cells []string, face textFace, style TextStyle) (row tableRow) {
+		for idx := 0; idx < count; idx++ {
+			text := ""
+			if idx < len(cells) {
+				text = cells[idx]
+			}
+			cellStyle := style
+			if columns[idx].Align != "" {
+				cellStyle.Align = columns[idx].Align
+			}
+			cell := tableCell{face: face, style: cellStyle}
+			if text != "" {
+				cell.layout = layoutText(face, text, cellStyle, math.Max(0, table.columns[idx]-2*pad), math.Inf(1))
+			}
+			row.height = math.Max(row.height, cell.layout.height+pad)
+			row.cells = append(row.cells, cell)
+		}
+		return
+	}
+
+	table.rule = float64(node.RuleWidth)
+	if titles != nil {
+		header := layoutRow(titles, headerFace, headerStyle)
+		table.header = &header
+		table.height += header.height + math.Max(1, table.rule)
+	}
+	for idx, cells := range rows {
+		row := layoutRow(cells, bodyFace, bodyStyle)
+		table.rows = append(table.rows, row)
+		table.height += row.height
+		if idx > 0 {
+			table.height += table.rule
+		}
+	}
+	for _, column := range table.columns {
+		table.width += column
+	}
+
+	return
+}
+
+// drawTable draws a table node's rows into bounds
+func (r flexRenderEngine) drawTable(node *Node, rows [][]string, bounds image.Rectangle, dst *image.RGBA) {
+
+	table := r.layoutTable(node, rows, float64(bounds.Dx()))
+	clipped := dst.SubImage(bounds).(*image.RGBA)
+	pad := float64(node.CellPadding)
+
+	ruleColor := ColorFromString(node.RuleColor)
+	if ruleColor == nil {
+		ruleColor = ColorBlack
+	}
+	rule := func(y, height float64) {
+		rect := image.Rect(bounds.Min.X, int(math.Round(y)), bounds.Min.X+int(math.Round(table.width)), int(math.Round(y+height)))
+		draw.Draw(clipped, rect, &image.Uniform{ruleColor}, image.ZP, draw.Src)
+	}
+
+	drawRow := func(row tableRow, y float64, background string) {
+		rowRect := image.Rect(bounds.Min.X, int(math.Round(y)), bounds.Min.X+int(math.Round(table.width)), int(math.Round(y+row.height)))
+		if c := ColorFromString(background); c != nil {
+			draw.Draw(clipped, rowRect, &image.Uniform{c}, image.ZP, draw.Src)
+		}
+		x := float64(bounds.Min.X)
+		for idx, cell := range row.cells {
+			cellRect := image.Rect(
+				int(math.Round(x+pad)), int(math.Round(y+pad/2)),
+				int(math.Round(x+table.columns[idx]-pad)), int(math.Round(y+row.height-pad/2)),
+			)
+			x += table.columns[idx]
+			if len(cell.layout.lines) == 0 {
+				continue
+			}
+			textColor := cell.style.Color
+			if textColor == nil {
+				textColor = ColorBlack
+			}
+			drawLayout(cell.face, cell.layout, cell.style, &image.Uniform{textColor}, cellRect, clipped)
+		}
+	}
+
+	y := float64(bounds.Min.Y)
+	if table.header != nil {
+		drawRow(*table.header, y, node.HeaderBackgroundColor)
+		y += table.header.height
+		rule(y, math.Max(1, table.rule))
+		y += math.Max(1, table.rule)
+	}
+	for idx, row := range table.rows {
+		if idx > 0 && table.rule > 0 {
+			rule(y, table.rule)
+			y += table.rule
+		}
+		background := ""
+		if idx%2 == 1 {
+			background = node.StripeColor
+		}
+		drawRow(row, y, background)
+		y += row.height
+	}
+}
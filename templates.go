@@ -0,0 +1,452 @@
+package epd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrTemplateNotFound is returned when a loader has no
+// template of the name asked for
+var ErrTemplateNotFound = errors.New("Template not found")
+
+// templateExtensions are the file extensions tried, in order,
+// when loading a template by name
+var templateExtensions = []string{"", ".json", ".yaml", ".yml"}
+
+// templateClasses are named sets of node properties
+type templateClasses map[string]map[string]interface{}
+
+// TemplateLoader loads templates by name from json or yaml files
+// in a file system, such as a directory. Templates can build on
+// each other:
+//
+// A node with an "include" is replaced by the template in that
+// file, relative to the including file, with the node's other
+// properties on top. Nodes of the included template with a "slot"
+// are replaced by the including node's "slots" of that name,
+// or kept as they are if it doesn't fill them.
+//
+// The root node of a file can define "classes", named sets of
+// properties, which nodes pick up with a "class" of one or more
+// names. A node's own properties win over its classes, and later
+// classes win over earlier ones. Classes of the including file
+// win over those of the files it includes.
+//
+// Templates can also be registered by name, and the built in
+// templates are registered as auto, landscape and portrait.
+// A TemplateLoader is safe for concurrent use.
+type TemplateLoader struct {
+	fsys       fs.FS
+	mu         sync.RWMutex
+	registered map[string]RenderTemplate
+	cache      map[string]RenderTemplate
+}
+
+// NewTemplateLoader returns a loader of templates from fsys.
+// fsys may be nil to only use registered templates.
+func NewTemplateLoader(fsys fs.FS) *TemplateLoader {
+	return &TemplateLoader{
+		fsys: fsys,
+		registered: map[string]RenderTemplate{
+			"auto":      TplDefaultAuto,
+			"landscape": TplDefaulltLandscape,
+			"portrait":  TplDefaultPortrait,
+		},
+		cache: map[string]RenderTemplate{},
+	}
+}
+
+// NewTemplateDir returns a loader of templates from the directory dir
+func NewTemplateDir(dir string) *TemplateLoader {
+	return NewTemplateLoader(os.DirFS(dir))
+}
+
+// Register adds a template by name. Templates in files
+// win over registered templates of the same name.
+func (l *TemplateLoader) Register(name string, tpl RenderTemplate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.registered[name] = tpl
+}
+
+// Names lists the templates that can be loaded, the registered
+// templates and the template files at the top of the file system.
+func (l *TemplateLoader) Names() (names []string) {
+	l.mu.RLock()
+	seen := map[string]bool{}
+	for name := range l.registered {
+		seen[name] = true
+	}
+	l.mu.RUnlock()
+
+	if l.fsys != nil {
+		entries, _ := fs.ReadDir(l.fsys, ".")
+		for _, entry := range entries {
+			ext := path.Ext(entry.Name())
+			if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			seen[strings.TrimSuffix(entry.Name(), ext)] = true
+		}
+	}
+
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Load returns the template called name, a file in the loader's
+// file system with or without its extension, or a registered
+// template. Includes, slots and classes are resolved, so the
+// template is plain json a renderer can use.
+func (l *TemplateLoader) Load(name string) (tpl RenderTemplate, err error) {
+
+	l.mu.RLock()
+	tpl, cached := l.cache[name]
+	registered, isRegistered := l.registered[name]
+	l.mu.RUnlock()
+	if cached {
+		return
+	}
+
+	file := l.find(name)
+	if file == "" {
+		if isRegistered {
+			return registered, nil
+		}
+		return tpl, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	classes := templateClasses{}
+	root, err := l.resolveFile(file, nil, classes)
+	if err != nil {
+		return
+	}
+	if err = applyClasses(root, file, "$", classes); err != nil {
+		return
+	}
+	data, err := json.Marshal(root)
+	if err != nil {
+		return
+	}
+	tpl = RenderTemplate(data)
+
+	l.mu.Lock()
+	l.cache[name] = tpl
+	l.mu.Unlock()
+	return
+}
+
+// find returns the file of the template called name,
+// or "" if there isn't one
+func (l *TemplateLoader) find(name string) string {
+	if l.fsys == nil {
+		return ""
+	}
+	for _, ext := range templateExtensions {
+		if info, err := fs.Stat(l.fsys, name+ext); err == nil && !info.IsDir() {
+			return name + ext
+		}
+	}
+	return ""
+}
+
+// readTemplateFile parses a json or yaml template file into its
+// root node, and takes the classes it defines off the root
+func (l *TemplateLoader) readTemplateFile(file string) (root map[string]interface{}, classes templateClasses, err error) {
+
+	data, err := fs.ReadFile(l.fsys, file)
+	if err != nil {
+		return
+	}
+	var parsed interface{}
+	switch path.Ext(file) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &parsed)
+	default:
+		err = json.Unmarshal(data, &parsed)
+	}
+	if err != nil {
+		return nil, nil, &TemplateError{Path: file, Err: err}
+	}
+
+	root, isNode := parsed.(map[string]interface{})
+	if !isNode {
+		return nil, nil, &TemplateError{Path: file + ":$", Err: errors.New("A template must be a node object")}
+	}
+
+	classes = templateClasses{}
+	if defined, ok := root["classes"]; ok {
+		definitions, isMap := defined.(map[string]interface{})
+		if !isMap {
+			return nil, nil, &TemplateError{Path: file + ":$.classes", Err: errors.New("Classes must be an object of property objects")}
+		}
+		for name, properties := range definitions {
+			props, isMap := properties.(map[string]interface{})
+			if !isMap {
+				return nil, nil, &TemplateError{Path: file + ":$.classes." + name, Err: errors.New("A class must be an object of properties")}
+			}
+			classes[name] = props
+		}
+		delete(root, "classes")
+	}
+	return
+}
+
+// resolveFile reads a template file and resolves its includes.
+// Its classes are added to classes, unless already defined by
+// a file that includes it. stack is the files including it.
+func (l *TemplateLoader) resolveFile(file string, stack []string, classes templateClasses) (root map[string]interface{}, err error) {
+
+	for _, including := range stack {
+		if including == file {
+			return nil, &TemplateError{Path: file, Err: fmt.Errorf("Included in itself by %s", strings.Join(stack, " > "))}
+		}
+	}
+
+	root, fileClasses, err := l.readTemplateFile(file)
+	if err != nil {
+		return
+	}
+	for name, props := range fileClasses {
+		if _, defined := classes[name]; !defined {
+			classes[name] = props
+		}
+	}
+
+	return l.resolveNode(root, file, "$", append(append([]string(nil), stack...), file), classes)
+}
+
+// resolveNode resolves the includes of a node and its descendants.
+// file is the file the node is written in.
+func (l *TemplateLoader) resolveNode(node map[string]interface{}, file, nodePath string, stack []string, classes templateClasses) (resolved map[string]interface{}, err error) {
+
+	if children, ok := node["children"]; ok {
+		list, isList := children.([]interface{})
+		if !isList {
+			return nil, &TemplateError{Path: file + ":" + nodePath + ".children", Err: errors.New("Children must be a list of nodes")}
+		}
+		for idx, child := range list {
+			if list[idx], err = l.resolveChild(child, file, fmt.Sprintf("%s.children[%d]", nodePath, idx), stack, classes); err != nil {
+				return
+			}
+		}
+	}
+	if more, ok := node["more"]; ok {
+		if node["more"], err = l.resolveChild(more, file, nodePath+".more", stack, classes); err != nil {
+			return
+		}
+	}
+
+	include, hasInclude := node["include"]
+	if !hasInclude {
+		return node, nil
+	}
+	includeFile, isString := include.(string)
+	if !isString {
+		return nil, &TemplateError{Path: file + ":" + nodePath + ".include", Err: errors.New("Include must be a file name")}
+	}
+
+	// Slots are filled with nodes written in this file
+	fills := map[string][]interface{}{}
+	if slots, ok := node["slots"]; ok {
+		slotMap, isMap := slots.(map[string]interface{})
+		if !isMap {
+			return nil, &TemplateError{Path: file + ":" + nodePath + ".slots", Err: errors.New("Slots must be an object of nodes or lists of nodes")}
+		}
+		for name, fill := range slotMap {
+			list, isList := fill.([]interface{})
+			if !isList {
+				list = []interface{}{fill}
+			}
+			for idx, child := range list {
+				if list[idx], err = l.resolveChild(child, file, fmt.Sprintf("%s.slots.%s[%d]", nodePath, name, idx), stack, classes); err != nil {
+					return
+				}
+			}
+			fills[name] = list
+		}
+	}
+
+	resolved, err = l.resolveFile(path.Join(path.Dir(file), includeFile), stack, classes)
+	if err != nil {
+		return
+	}
+	fillSlots(resolved, fills)
+	for key, value := range node {
+		if key != "include" && key != "slots" {
+			resolved[key] = value
+		}
+	}
+	return
+}
+
+func (l *TemplateLoader) resolveChild(child interface{}, file, nodePath string, stack []string, classes templateClasses) (resolved map[string]interface{}, err error) {
+	node, isNode := child.(map[string]interface{})
+	if !isNode {
+		return nil, &TemplateError{Path: file + ":" + nodePath, Err: errors.New("Expected a node object")}
+	}
+	return l.resolveNode(node, file, nodePath, stack, classes)
+}
+
+// fillSlots replaces the slot nodes under node with the nodes
+// filling them. Unfilled slots are left for an outer include
+// to fill, or to be drawn as they are.
+func fillSlots(node map[string]interface{}, fills map[string][]interface{}) {
+	if len(fills) == 0 {
+		return
+	}
+	children, _ := node["children"].([]interface{})
+	var filled []interface{}
+	for _, child := range children {
+		childNode, _ := child.(map[string]interface{})
+		if name, isSlot := childNode["slot"].(string); isSlot {
+			if fill, ok := fills[name]; ok {
+				filled = append(filled, fill...)
+				continue
+			}
+		}
+		fillSlots(childNode, fills)
+		filled = append(filled, child)
+	}
+	if children != nil {
+		node["children"] = filled
+	}
+	if more, ok := node["more"].(map[string]interface{}); ok {
+		fillSlots(more, fills)
+	}
+}
+
+// applyClasses sets the properties of a node's classes that it
+// doesn't set itself, and removes what's left of slots, for
+// node and its descendants
+func applyClasses(node map[string]interface{}, file, nodePath string, classes templateClasses) (err error) {
+
+	if class, ok := node["class"]; ok {
+		names, isString := class.(string)
+		if !isString {
+			return &TemplateError{Path: file + ":" + nodePath + ".class", Err: errors.New("Class must be a string of class names")}
+		}
+		props := map[string]interface{}{}
+		for _, name := range strings.Fields(names) {
+			defined, ok := classes[name]
+			if !ok {
+				return &TemplateError{Path: file + ":" + nodePath + ".class", Err: fmt.Errorf("Unknown class %s", name)}
+			}
+			for key, value := range defined {
+				props[key] = value
+			}
+		}
+		for key, value := range props {
+			if _, set := node[key]; !set {
+				node[key] = value
+			}
+		}
+		delete(node, "class")
+	}
+	delete(node, "slot")
+
+	children, _ := node["children"].([]interface{})
+	for idx, child := range children {
+		if err = applyClasses(child.(map[string]interface{}), file, fmt.Sprintf("%s.children[%d]", nodePath, idx), classes); err != nil {
+			return
+		}
+	}
+	if more, ok := node["more"].(map[string]interface{}); ok {
+		err = applyClasses(more, file, nodePath+".more", classes)
+	}
+	return
+}
+
+// Watch checks the loader's files for changes every interval.
+// When they change, loaded templates are forgotten so they're
+// loaded afresh, and changed is called with the changed file.
+// It returns a function that stops watching.
+func (l *TemplateLoader) Watch(interval time.Duration, changed func(file string)) (stop func()) {
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+	if l.fsys == nil {
+		return
+	}
+
+	last := l.snapshot()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			current := l.snapshot()
+			var changes []string
+			for file, info := range current {
+				if last[file] != info {
+					changes = append(changes, file)
+				}
+			}
+			for file := range last {
+				if _, ok := current[file]; !ok {
+					changes = append(changes, file)
+				}
+			}
+			last = current
+			if len(changes) == 0 {
+				continue
+			}
+
+			// Includes make it hard to tell which templates
+			// a file is part of, so all are forgotten
+			l.mu.Lock()
+			l.cache = map[string]RenderTemplate{}
+			l.mu.Unlock()
+
+			sort.Strings(changes)
+			for _, file := range changes {
+				log.Debugf("Template file %s changed", file)
+				if changed != nil {
+					changed(file)
+				}
+			}
+		}
+	}()
+	return
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot records the state of every file in the file system
+func (l *TemplateLoader) snapshot() (files map[string]fileState) {
+	files = map[string]fileState{}
+	fs.WalkDir(l.fsys, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, errr := entry.Info(); errr == nil {
+			files[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,261 @@
+package epd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func mapFile(data string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(data)}
+}
+
+func TestTemplateLoader(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		load  string
+		want  string
+		// err is the path of the template error expected,
+		// and message part of its message
+		err     string
+		message string
+	}{
+		{
+			name: "include with properties on top",
+			files: fstest.MapFS{
+				"main.json": mapFile(`{"children": [{"include": "parts/card.json", "id": "weather", "padding": 10}]}`),
+				"parts/card.json": mapFile(`{"type": "div", "id": "card", "padding": 5, "children": [
+					{"include": "header.json"}, {"id": "text", "type": "text"}]}`),
+				"parts/header.json": mapFile(`{"id": "header", "type": "text", "fontSize": 2}`),
+			},
+			load: "main",
+			want: `{"children": [{"type": "div", "id": "weather", "padding": 10, "children": [
+				{"id": "header", "type": "text", "fontSize": 2}, {"id": "text", "type": "text"}]}]}`,
+		},
+		{
+			name: "filled and unfilled slots",
+			files: fstest.MapFS{
+				"layout.json": mapFile(`{"children": [
+					{"slot": "header", "id": "title"},
+					{"type": "div", "children": [{"slot": "body", "id": "placeholder"}]},
+					{"slot": "footer", "id": "footer"}]}`),
+				"page.json": mapFile(`{"include": "layout.json", "id": "page", "slots": {
+					"body": [{"id": "left"}, {"id": "right"}],
+					"footer": {"id": "status"}}}`),
+			},
+			load: "page",
+			want: `{"id": "page", "children": [
+				{"id": "title"},
+				{"type": "div", "children": [{"id": "left"}, {"id": "right"}]},
+				{"id": "status"}]}`,
+		},
+		{
+			name: "class precedence",
+			files: fstest.MapFS{
+				"main.json": mapFile(`{
+					"classes": {"big": {"fontSize": 2, "color": "red"}, "blue": {"color": "blue"}},
+					"children": [
+						{"id": "a", "class": "big blue"},
+						{"id": "b", "class": "blue big"},
+						{"id": "c", "class": "big", "fontSize": 3}]}`),
+			},
+			load: "main",
+			want: `{"children": [
+				{"id": "a", "fontSize": 2, "color": "blue"},
+				{"id": "b", "fontSize": 2, "color": "red"},
+				{"id": "c", "fontSize": 3, "color": "red"}]}`,
+		},
+		{
+			name: "including file classes win",
+			files: fstest.MapFS{
+				"inner.json": mapFile(`{
+					"classes": {"label": {"color": "red"}, "big": {"fontSize": 2}},
+					"children": [{"id": "inner", "class": "label big"}]}`),
+				"outer.json": mapFile(`{
+					"classes": {"label": {"color": "blue"}},
+					"children": [{"include": "inner.json"}, {"id": "outer", "class": "label"}]}`),
+			},
+			load: "outer",
+			want: `{"children": [
+				{"children": [{"id": "inner", "color": "blue", "fontSize": 2}]},
+				{"id": "outer", "color": "blue"}]}`,
+		},
+		{
+			name: "unknown class",
+			files: fstest.MapFS{
+				"main.json": mapFile(`{"children": [{"id": "a", "class": "missing"}]}`),
+			},
+			load: "main",
+			err:  "main.json:$.children[0].class",
+		},
+		{
+			name: "include cycle",
+			files: fstest.MapFS{
+				"a.json": mapFile(`{"children": [{"include": "b.json"}]}`),
+				"b.json": mapFile(`{"children": [{"include": "a.json"}]}`),
+			},
+			load:    "a",
+			err:     "a.json",
+			message: "Included in itself by a.json > b.json",
+		},
+		{
+			name: "included in itself",
+			files: fstest.MapFS{
+				"a.json": mapFile(`{"more": {"include": "a.json"}}`),
+			},
+			load: "a.json",
+			err:  "a.json",
+		},
+		{
+			name: "children not a list",
+			files: fstest.MapFS{
+				"main.json": mapFile(`{"children": [{"children": {"id": "a"}}]}`),
+			},
+			load: "main",
+			err:  "main.json:$.children[0].children",
+		},
+		{
+			name: "invalid json",
+			files: fstest.MapFS{
+				"main.json": mapFile(`{"children": [`),
+			},
+			load: "main",
+			err:  "main.json",
+		},
+		{
+			name: "yaml",
+			files: fstest.MapFS{
+				"main.yaml": mapFile("classes:\n  big: {fontSize: 2}\nchildren:\n  - id: a\n    class: big\n  - include: part.yml\n"),
+				"part.yml":  mapFile("id: b\ntype: text\n"),
+			},
+			load: "main",
+			want: `{"children": [{"id": "a", "fontSize": 2}, {"id": "b", "type": "text"}]}`,
+		},
+		{
+			name: "files win over registered",
+			files: fstest.MapFS{
+				"landscape.json": mapFile(`{"id": "mine"}`),
+			},
+			load: "landscape",
+			want: `{"id": "mine"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tpl, err := NewTemplateLoader(test.files).Load(test.load)
+			if test.err != "" {
+				var tplErr *TemplateError
+				if !errors.As(err, &tplErr) {
+					t.Fatalf("Expected a template error, got %v", err)
+				}
+				if tplErr.Path != test.err {
+					t.Errorf("Expected an error at %s, got %s", test.err, tplErr.Error())
+				}
+				if !strings.Contains(tplErr.Error(), test.message) {
+					t.Errorf("Expected an error of %q, got %s", test.message, tplErr.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, string(tpl), test.want)
+		})
+	}
+}
+
+func TestTemplateLoaderRegistered(t *testing.T) {
+	loader := NewTemplateLoader(nil)
+	loader.Register("clock", `{"id": "clock"}`)
+	if tpl, err := loader.Load("clock"); err != nil || tpl != `{"id": "clock"}` {
+		t.Errorf("Expected the registered template, got %q, %v", tpl, err)
+	}
+	if _, err := loader.Load("missing"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+	names := NewTemplateLoader(fstest.MapFS{
+		"clock.json":     mapFile(`{}`),
+		"notes.yaml":     mapFile(`{}`),
+		"readme.txt":     mapFile(``),
+		"parts/box.json": mapFile(`{}`),
+	}).Names()
+	want := []string{"auto", "clock", "landscape", "notes", "portrait"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected names %v, got %v", want, names)
+	}
+}
+
+// lockedFS is a MapFS that can be changed while it's watched
+type lockedFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+func (l *lockedFS) Open(name string) (fs.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.files.Open(name)
+}
+
+func (l *lockedFS) write(name, data string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[name] = &fstest.MapFile{Data: []byte(data), ModTime: time.Now()}
+}
+
+func TestTemplateLoaderWatch(t *testing.T) {
+	fsys := &lockedFS{files: fstest.MapFS{
+		"main.json": mapFile(`{"children": [{"include": "part.json"}]}`),
+		"part.json": mapFile(`{"id": "old"}`),
+	}}
+	loader := NewTemplateLoader(fsys)
+	tpl, err := loader.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, string(tpl), `{"children": [{"id": "old"}]}`)
+
+	changed := make(chan string, 1)
+	stop := loader.Watch(10*time.Millisecond, func(file string) {
+		changed <- file
+	})
+	defer stop()
+
+	// The cached template is loaded until the watcher sees the change
+	fsys.write("part.json", `{"id": "new"}`)
+	select {
+	case file := <-changed:
+		if file != "part.json" {
+			t.Errorf("Expected part.json to change, got %s", file)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The change wasn't seen")
+	}
+	tpl, err = loader.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, string(tpl), `{"children": [{"id": "new"}]}`)
+}
+
+func assertJSONEqual(t *testing.T, got, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+		t.Fatalf("Invalid json %s: %s", got, err.Error())
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("Invalid json %s: %s", want, err.Error())
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
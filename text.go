@@ -0,0 +1,387 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	TextAlignLeft    = "left"
+	TextAlignCenter  = "center"
+	TextAlignRight   = "right"
+	TextAlignJustify = "justify"
+
+	VerticalAlignTop    = "top"
+	VerticalAlignMiddle = "middle"
+	VerticalAlignBottom = "bottom"
+
+	TextOverflowVisible  = "visible"
+	TextOverflowClip     = "clip"
+	TextOverflowEllipsis = "ellipsis"
+)
+
+const (
+	// FitNone leaves text at its font size
+	FitNone = "none"
+	// FitShrink shrinks text until it fits its box
+	FitShrink = "shrink"
+)
+
+const ellipsis = "…"
+
+// TextStyle controls how text is laid out in its box
+type TextStyle struct {
+	// FontScale is the size of the text as a multiple
+	// of the renderer's default font size
+	FontScale float64
+	// FontFamily is the name of a family in the renderer's
+	// font registry. Empty is the default family.
+	FontFamily string
+	// FontWeight is a css weight such as 400 for normal
+	// or 700 for bold. 0 is normal.
+	FontWeight int
+	// FontStyle is normal or italic
+	FontStyle string
+	// Color is the colour of the text. nil is black.
+	Color color.Color
+	// Align is one of left (default), center, right or justify.
+	// Justified text is spread to fill each line but the last
+	// of each paragraph.
+	Align string
+	// VerticalAlign is one of top (default), middle or bottom
+	VerticalAlign string
+	// LineHeight is a multiple of the font's line height.
+	// 0 is the same as 1.
+	LineHeight float64
+	// LetterSpacing is extra space between characters in pixels
+	LetterSpacing float64
+	// MaxLines limits the number of lines. 0 is unlimited.
+	MaxLines int
+	// Overflow is what to do with lines that don't fit in the
+	// box or are over MaxLines. One of visible (default), clip
+	// or ellipsis. Visible text is still drawn outside the box.
+	Overflow string
+	// Format is plain (default) or markdown
+	Format string
+}
+
+// textFace measures text in a face, spaced as for a style
+type textFace struct {
+	font.Face
+	letterSpacing float64
+	// family, weight, style and size in pixels describe the face
+	// for output formats that draw text themselves
+	family string
+	weight int
+	style  string
+	size   float64
+}
+
+func (f textFace) measure(s string) float64 {
+	width := Int26_6ToFloat64(font.MeasureString(f.Face, s))
+	if f.letterSpacing != 0 {
+		if chars := len(splitRunes(s)); chars > 1 {
+			width += f.letterSpacing * float64(chars-1)
+		}
+	}
+	return width
+}
+
+// extend returns the width of text, measured as width, with s
+// added to its end. It's the same as measuring them together,
+// without measuring text all over again.
+func (f textFace) extend(text string, width float64, s string) float64 {
+	if text == "" {
+		return f.measure(s)
+	}
+	if s == "" {
+		return width
+	}
+	last, _ := utf8.DecodeLastRuneInString(text)
+	first, _ := utf8.DecodeRuneInString(s)
+	return width + Int26_6ToFloat64(f.Kern(last, first)) + f.letterSpacing + f.measure(s)
+}
+
+type textLine struct {
+	text  string
+	width float64
+	// paraEnd marks the last line of a paragraph,
+	// which isn't stretched when justified
+	paraEnd bool
+}
+
+// textLayout is text wrapped and truncated to fit a box
+type textLayout struct {
+	lines      []textLine
+	lineHeight float64
+	ascent     float64
+	width      float64
+	height     float64
+	// overflow is true when there were more lines
+	// than could fit in the box
+	overflow bool
+}
+
+// layoutText lays text out in a box of width by height pixels.
+// A height of +Inf doesn't limit the lines.
+func layoutText(face textFace, text string, style TextStyle, width, height float64) (layout textLayout) {
+
+	metrics := face.Metrics()
+	lineScale := style.LineHeight
+	if lineScale <= 0 {
+		lineScale = 1
+	}
+	fontHeight := Int26_6ToFloat64(metrics.Height)
+	layout.lineHeight = fontHeight * lineScale
+	// Extra line height is split above and below the line
+	layout.ascent = Int26_6ToFloat64(metrics.Ascent) + (layout.lineHeight-fontHeight)/2
+
+	for _, line := range wrapText(face, text, width) {
+		layout.lines = append(layout.lines, textLine{text: line})
+	}
+	for idx := range layout.lines {
+		last := idx == len(layout.lines)-1
+		layout.lines[idx].paraEnd = last || layout.lines[idx+1].text == ""
+	}
+
+	truncated := false
+	if style.MaxLines > 0 && style.MaxLines < len(layout.lines) {
+		layout.lines = layout.lines[:style.MaxLines]
+		layout.overflow = true
+		truncated = true
+	}
+
+	if fit := math.Floor(height / layout.lineHeight); fit < float64(len(layout.lines)) {
+		layout.overflow = true
+		if style.Overflow == TextOverflowClip || style.Overflow == TextOverflowEllipsis {
+			layout.lines = layout.lines[:int(math.Max(fit, 1))]
+			truncated = true
+		}
+	}
+
+	if truncated {
+		for len(layout.lines) > 1 && layout.lines[len(layout.lines)-1].text == "" {
+			layout.lines = layout.lines[:len(layout.lines)-1]
+		}
+		last := &layout.lines[len(layout.lines)-1]
+		last.paraEnd = true
+		if style.Overflow == TextOverflowEllipsis {
+			last.text = ellipsize(face, last.text, width)
+		}
+	}
+
+	for idx, line := range layout.lines {
+		layout.lines[idx].width = face.measure(line.text)
+		layout.width = math.Max(layout.width, layout.lines[idx].width)
+	}
+	layout.height = layout.lineHeight * float64(len(layout.lines))
+
+	return
+}
+
+// ellipsize shortens a line until it fits in width
+// with an ellipsis added to the end
+func ellipsize(face textFace, line string, width float64) string {
+	chars := splitRunes(line)
+	for len(chars) > 0 && face.measure(strings.Join(chars, "")+ellipsis) > width {
+		chars = chars[:len(chars)-1]
+	}
+	return strings.TrimRight(strings.Join(chars, ""), " ") + ellipsis
+}
+
+// drawLayout draws laid out text into bounds on dst
+func drawLayout(face textFace, layout textLayout, style TextStyle, src image.Image, bounds image.Rectangle, dst *image.RGBA) {
+
+	if style.Overflow == TextOverflowClip || style.Overflow == TextOverflowEllipsis {
+		dst = dst.SubImage(bounds).(*image.RGBA)
+	}
+
+	boxWidth := float64(bounds.Dx())
+	top := alignedTop(style, bounds, layout.height)
+
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  src,
+		Face: face.Face,
+	}
+
+	for idx, line := range layout.lines {
+		x := alignedLeft(style, bounds, line.width)
+
+		drawer.Dot = fixed.Point26_6{
+			X: floatToFixed(x),
+			Y: floatToFixed(top + layout.ascent + float64(idx)*layout.lineHeight),
+		}
+
+		if style.Align == TextAlignJustify && !line.paraEnd {
+			drawJustified(drawer, face, line, boxWidth)
+		} else {
+			drawSpaced(drawer, face, line.text, 0, false)
+		}
+	}
+
+}
+
+// alignedTop is the top of text height pixels high,
+// vertically aligned in bounds
+func alignedTop(style TextStyle, bounds image.Rectangle, height float64) float64 {
+	top := float64(bounds.Min.Y)
+	switch style.VerticalAlign {
+	case VerticalAlignMiddle:
+		top += (float64(bounds.Dy()) - height) / 2
+	case VerticalAlignBottom:
+		top += float64(bounds.Dy()) - height
+	}
+	return top
+}
+
+// alignedLeft is the left of a line width pixels wide,
+// aligned in bounds
+func alignedLeft(style TextStyle, bounds image.Rectangle, width float64) float64 {
+	x := float64(bounds.Min.X)
+	switch style.Align {
+	case TextAlignCenter:
+		x += (float64(bounds.Dx()) - width) / 2
+	case TextAlignRight:
+		x += float64(bounds.Dx()) - width
+	}
+	return x
+}
+
+// drawJustified draws a line stretched to width. Extra space
+// goes between words, or between characters if the line
+// has no spaces, as in CJK text.
+func drawJustified(drawer *font.Drawer, face textFace, line textLine, width float64) {
+	chars := splitRunes(line.text)
+	gaps := strings.Count(line.text, " ")
+	spacesOnly := gaps > 0
+	if !spacesOnly {
+		gaps = len(chars) - 1
+	}
+	if gaps <= 0 {
+		drawSpaced(drawer, face, line.text, 0, false)
+		return
+	}
+	drawSpaced(drawer, face, line.text, (width-line.width)/float64(gaps), spacesOnly)
+}
+
+// drawSpaced draws text a character at a time, adding the face's
+// letter spacing between characters and extra space after spaces,
+// or after every character if spacesOnly is false.
+func drawSpaced(drawer *font.Drawer, face textFace, text string, extra float64, spacesOnly bool) {
+	if face.letterSpacing == 0 && extra == 0 {
+		drawer.DrawString(text)
+		return
+	}
+	chars := splitRunes(text)
+	prev := rune(-1)
+	for idx, char := range chars {
+		r := []rune(char)[0]
+		if prev >= 0 {
+			drawer.Dot.X += face.Kern(prev, r)
+		}
+		drawer.DrawString(char)
+		prev = []rune(char)[len([]rune(char))-1]
+		if idx == len(chars)-1 {
+			break
+		}
+		drawer.Dot.X += floatToFixed(face.letterSpacing)
+		if !spacesOnly || char == " " {
+			drawer.Dot.X += floatToFixed(extra)
+		}
+	}
+}
+
+// wrapText splits text into lines that fit within width pixels
+// when drawn with face. Lines break at the line break
+// opportunities of the text, so between words for Latin text
+// and between characters for CJK text. A word too long to fit
+// on a line by itself is broken between its characters.
+// Paragraphs, separated by newlines, are separated by an
+// empty line.
+func wrapText(face textFace, text string, width float64) (lines []string) {
+
+	// Lines are remembered by the face, so text flex measures at
+	// the same width again isn't wrapped again
+	cached, _ := face.Face.(*cachedFace)
+	key := wrapKey{text: text, width: width, letterSpacing: face.letterSpacing}
+	if cached != nil {
+		if lines, ok := cached.wrapped(key); ok {
+			return lines
+		}
+	}
+
+	paras := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for idx, para := range paras {
+		if idx > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, wrapParagraph(face, para, width)...)
+	}
+
+	if cached != nil {
+		cached.setWrapped(key, lines)
+	}
+	return
+}
+
+// wrapParagraph wraps a paragraph, keeping track of the width of
+// the line so far rather than measuring it again for every segment
+func wrapParagraph(face textFace, para string, width float64) (lines []string) {
+
+	// lineWidth includes any spaces at the end of line
+	line, lineWidth := "", 0.0
+	for _, segment := range lineSegments(para) {
+		segmentWidth := face.extend(line, lineWidth, strings.TrimRight(segment, " "))
+		if line != "" && segmentWidth > width {
+			lines = append(lines, strings.TrimRight(line, " "))
+			line, lineWidth = "", 0
+			segmentWidth = face.measure(strings.TrimRight(segment, " "))
+		}
+
+		// A single segment wider than the line has to be
+		// broken wherever it can be
+		for line == "" && segmentWidth > width {
+			head, tail := breakSegment(face, segment, width)
+			if tail == "" {
+				break
+			}
+			lines = append(lines, head)
+			segment = tail
+			segmentWidth = face.measure(strings.TrimRight(segment, " "))
+		}
+
+		lineWidth = face.extend(line, lineWidth, segment)
+		line += segment
+	}
+
+	return append(lines, strings.TrimRight(line, " "))
+}
+
+// breakSegment breaks a segment between characters, returning
+// as much as fits in width as head. head always has at least one
+// character.
+func breakSegment(face textFace, segment string, width float64) (head, tail string) {
+	chars := splitRunes(segment)
+	end := 1
+	headWidth := face.measure(chars[0])
+	for end < len(chars) {
+		next := face.extend(chars[end-1], headWidth, chars[end])
+		if next > width {
+			break
+		}
+		headWidth = next
+		end++
+	}
+	return strings.Join(chars[:end], ""), strings.Join(chars[end:], "")
+}
+
+func floatToFixed(x float64) fixed.Int26_6 {
+	return fixed.Int26_6(math.Round(x * 64))
+}
@@ -0,0 +1,106 @@
+package epd
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// The benchmarks lay out and render text as a Raspberry Pi driving
+// a 400x300 panel would, at 72 dpi with the go font. Run them with
+// -cpu 1 to compare with a single core board.
+
+const benchParagraph = `The quick brown fox jumps over the lazy dog, while the ` +
+	`weather station on the roof reports a light breeze from the south west ` +
+	`and a chance of rain later in the afternoon. Readings are sent every ` +
+	`five minutes and shown on the panel in the kitchen.`
+
+// benchBody is a long body of text, as in a news or notes template
+var benchBody = strings.Repeat(benchParagraph+"\n", 20)
+
+func benchEngine() flexRenderEngine {
+	return NewFlexRenderEngineWithFonts(12, 72, NewFontRegistry())
+}
+
+func BenchmarkWrapText(b *testing.B) {
+	r := benchEngine()
+	face := r.textFace(TextStyle{FontScale: 1})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wrapText(face, benchBody, 380)
+	}
+}
+
+// BenchmarkWrapTextUncached wraps in a face that isn't cached,
+// so the text is wrapped every time
+func BenchmarkWrapTextUncached(b *testing.B) {
+	face := textFace{Face: NewFontRegistry().Source(FontFamilyGo, FontWeightNormal, FontStyleNormal).Face(12, 72)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wrapText(face, benchBody, float64(200+i%200))
+	}
+}
+
+func BenchmarkMeasureText(b *testing.B) {
+	r := benchEngine()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.MeasureText(benchBody, TextStyle{FontScale: 1}, 380)
+	}
+}
+
+func BenchmarkFitText(b *testing.B) {
+	r := benchEngine()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.FitText(benchParagraph, TextStyle{FontScale: 1}, 0.5, 3, 380, 120)
+	}
+}
+
+func BenchmarkRenderLongBody(b *testing.B) {
+	r := benchEngine()
+	content := RenderContent{
+		"title":  "Notes",
+		"body":   benchBody,
+		"footer": "Updated just now",
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Render(content, 400, 300, TplDefaulltLandscape); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderMarkdown(b *testing.B) {
+	r := benchEngine()
+	tpl := RenderTemplate(`{"children": [{"id": "notes", "type": "text", "format": "markdown", "fit": "shrink", "flexGrow": 1}]}`)
+	content := RenderContent{
+		"notes": "# Notes\n\n" + strings.Repeat("- **"+benchParagraph[:40]+"** "+benchParagraph[40:120]+"\n", 10),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Render(content, 400, 300, tpl); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestWrapTextWidths checks that lines are as wide as they're
+// measured to be, however the widths were worked out
+func TestWrapTextWidths(t *testing.T) {
+	r := NewFlexRenderEngineWithFonts(12, 72, NewFontRegistry())
+	for _, spacing := range []float64{0, 1.5} {
+		face := r.textFace(TextStyle{FontScale: 1, LetterSpacing: spacing})
+		for _, width := range []float64{40, 120, 380} {
+			for _, line := range wrapText(face, benchParagraph+" Supercalifragilisticexpialidocious", width) {
+				if w := face.measure(line); w > width && len(splitRunes(line)) > 1 {
+					t.Errorf("Line %q is %.2f wide, over %.0f", line, w, width)
+				}
+			}
+		}
+	}
+	if lines := wrapText(r.textFace(TextStyle{FontScale: 1}), benchParagraph, math.Inf(1)); len(lines) != 1 {
+		t.Errorf("Expected 1 line at an infinite width, got %d", len(lines))
+	}
+}
@@ -0,0 +1,687 @@
+package epd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/kjk/flex"
+)
+
+// Problem is a mistake in a template found by ValidateTemplate.
+// Path is a json path to the node or property at fault, such
+// as `$.children[1].alignContent`.
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// nodeTypes are the node types with a meaning. Nodes of any
+// other type are drawn by their content, so "div", "text" and
+// "img" are only there for the reader.
+var nodeTypes = []string{
+	"div", "text", "img",
+	NodeTypeList, NodeTypeTable,
+	NodeTypeQR, NodeTypeBarcode,
+	NodeTypeSparkline, NodeTypeLine, NodeTypeBar, NodeTypeGauge,
+	NodeTypeSVG, NodeTypeIcon,
+}
+
+// propertyRule limits the values of a property beyond its
+// json type. The renderer falls back to a default for values
+// it doesn't know, so these are only checked here.
+type propertyRule struct {
+	// minimum is the least a number property can be
+	minimum *float64
+	// enum is the values the property can take
+	enum []string
+	// pattern is a regular expression for the schema, for
+	// properties checked by check
+	pattern string
+	// check returns why a value is wrong, or "" if it isn't
+	check func(value string) string
+}
+
+var dimensionPattern = `^\s*(auto|[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)(px|%)?)\s*$`
+
+var dimensionRule = propertyRule{
+	pattern: dimensionPattern,
+	check: func(value string) string {
+		if strings.TrimSpace(value) != "" && Dimension(value).Value().Unit == flex.UnitUndefined {
+			return fmt.Sprintf("Invalid dimension %q, expected a number of pixels, a percentage or auto", value)
+		}
+		return ""
+	},
+}
+
+var colorRule = propertyRule{
+	pattern: `^\s*(black|white|red|transparent|#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6})?\s*$`,
+	check: func(value string) string {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" && value != "transparent" && ColorFromString(value) == nil {
+			return fmt.Sprintf("Invalid colour %q, expected black, white, red, transparent or a hex colour", value)
+		}
+		return ""
+	},
+}
+
+// nodeRule is the rule of a node property. Dimensions, the
+// only properties that are numbers or strings, share a rule.
+func nodeRule(prop property) propertyRule {
+	if rule, ok := propertyRules[prop.name]; ok {
+		return rule
+	}
+	if len(prop.kinds) == 2 {
+		return dimensionRule
+	}
+	return propertyRule{}
+}
+
+// propertyRules are the rules of node properties by json name
+var propertyRules = map[string]propertyRule{
+	"type":                  {enum: nodeTypes},
+	"flexDirection":         {enum: mapKeys(FlexDirectionMap)},
+	"flexWrap":              {enum: mapKeys(FlexWrapMap)},
+	"justifyContent":        {enum: mapKeys(FlexJustifyMap)},
+	"alignItems":            {enum: mapKeys(FlexAlignMap)},
+	"alignContent":          {enum: mapKeys(FlexAlignMap)},
+	"alignSelf":             {enum: mapKeys(FlexAlignMap)},
+	"position":              {enum: mapKeys(FlexPositionTypeMap)},
+	"textAlign":             {enum: []string{TextAlignLeft, TextAlignCenter, TextAlignRight, TextAlignJustify}},
+	"verticalAlign":         {enum: []string{VerticalAlignTop, VerticalAlignMiddle, VerticalAlignBottom}},
+	"textOverflow":          {enum: []string{TextOverflowVisible, TextOverflowClip, TextOverflowEllipsis}},
+	"fit":                   {enum: []string{FitNone, FitShrink}},
+	"format":                {enum: []string{TextFormatPlain, TextFormatMarkdown}},
+	"fontStyle":             {enum: []string{FontStyleNormal, FontStyleItalic, "oblique"}},
+	"objectFit":             {enum: []string{ObjectFitContain, ObjectFitCover, ObjectFitFill, ObjectFitNone}},
+	"errorCorrection":       {enum: mapKeys(QRLevelMap)},
+	"symbology":             symbologyRule(),
+	"icon":                  {enum: IconNames()},
+	"fontWeight":            fontWeightRule(),
+	"objectPosition":        objectPositionRule(),
+	"color":                 colorRule,
+	"backgroundColor":       colorRule,
+	"borderColor":           colorRule,
+	"headerColor":           colorRule,
+	"headerBackgroundColor": colorRule,
+	"stripeColor":           colorRule,
+	"ruleColor":             colorRule,
+	"quietZone":             {minimum: floatPtr(0)},
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// columnRules are the rules of table column properties
+var columnRules = map[string]propertyRule{
+	"align": {enum: []string{TextAlignLeft, TextAlignCenter, TextAlignRight}},
+	"width": {
+		pattern: `^\s*(auto|([0-9]+\.?[0-9]*|\.[0-9]+)(px|%|fr)?)?\s*$`,
+	},
+}
+
+func fontWeightRule() propertyRule {
+	names := mapKeys(fontWeightNames)
+	return propertyRule{
+		pattern: `^([1-9][0-9]{0,2}|` + strings.Join(names, "|") + `)$`,
+		check: func(value string) string {
+			weight := strings.ToLower(strings.Replace(strings.Replace(value, "-", "", -1), " ", "", -1))
+			if _, ok := fontWeightNames[weight]; ok {
+				return ""
+			}
+			if w, err := strconv.Atoi(weight); err == nil && w > 0 && w < 1000 {
+				return ""
+			}
+			return fmt.Sprintf("Unknown fontWeight %q, expected a number from 1 to 999 or one of %s", value, strings.Join(names, ", "))
+		},
+	}
+}
+
+func symbologyRule() propertyRule {
+	symbologies := []string{BarcodeCode128, BarcodeEAN13}
+	return propertyRule{
+		pattern: `^([cC][oO][dD][eE]-?128|[eE][aA][nN]-?13)?$`,
+		check: func(value string) string {
+			symbology := strings.Replace(strings.ToLower(value), "-", "", -1)
+			if symbology == "" || contains(symbologies, symbology) {
+				return ""
+			}
+			return fmt.Sprintf("Unknown symbology %q, expected one of %s%s", value, strings.Join(symbologies, ", "), suggest(value, symbologies))
+		},
+	}
+}
+
+func objectPositionRule() propertyRule {
+	keywords := []string{"left", "center", "right", "top", "bottom"}
+	return propertyRule{
+		pattern: `^\s*((left|center|right|top|bottom|[0-9]+\.?[0-9]*%)\s*){0,2}$`,
+		check: func(value string) string {
+			for _, part := range strings.Fields(strings.ToLower(value)) {
+				if contains(keywords, part) {
+					continue
+				}
+				if _, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64); err == nil && strings.HasSuffix(part, "%") {
+					continue
+				}
+				return fmt.Sprintf("Invalid objectPosition %q, expected keywords such as \"left top\" or percentages", value)
+			}
+			return ""
+		},
+	}
+}
+
+// ValidateTemplate checks a template for mistakes the renderer
+// quietly ignores: unknown or miscased properties, values it
+// doesn't know and falls back to a default for, duplicate ids,
+// and ids that content can't be bound to. Templates that won't
+// parse have a single problem, the parse error.
+func ValidateTemplate(tpl RenderTemplate) (problems []Problem) {
+
+	var root interface{}
+	if err := json.Unmarshal([]byte(tpl), &root); err != nil {
+		_, err = ParseTemplate(tpl)
+		return []Problem{problemFromError("$", err)}
+	}
+
+	v := &validator{ids: map[string]string{}}
+	v.node(root, "$", "")
+	problems = v.problems
+
+	// Catch anything the checks above don't
+	if len(problems) == 0 {
+		if _, err := ParseTemplate(tpl); err != nil {
+			problems = append(problems, problemFromError("$", err))
+		}
+	}
+	return
+}
+
+func problemFromError(path string, err error) Problem {
+	if tplErr, ok := err.(*TemplateError); ok {
+		return Problem{Path: tplErr.Path, Message: tplErr.Err.Error()}
+	}
+	return Problem{Path: path, Message: err.Error()}
+}
+
+type validator struct {
+	problems []Problem
+	// ids is the path of the first node with each id
+	ids map[string]string
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// node checks a node and its children. repeater is the path of
+// the closest repeater the node is in, if any.
+func (v *validator) node(value interface{}, path, repeater string) {
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path, "Expected a node object, got %s", jsonTypeName(value))
+		return
+	}
+
+	for _, key := range sortedKeys(fields) {
+		prop, known := nodeProperties[key]
+		if !known {
+			if name := propertyName(nodeProperties, key); name != "" {
+				v.add(path+"."+key, "Property %s should be written %s", key, name)
+				prop = nodeProperties[name]
+			} else {
+				v.add(path+"."+key, "Unknown property %s%s", key, suggest(key, propertyNames(nodeProperties)))
+				continue
+			}
+		}
+		v.property(fields[key], path+"."+key, prop, nodeRule(prop))
+	}
+
+	v.expressions(fields, path)
+	v.id(fields, path, repeater)
+
+	// The more line of a repeater keeps its id, its children don't
+	if more, ok := field(fields, "more").(map[string]interface{}); ok {
+		v.node(more, path+".more", repeater)
+	}
+	if isRepeater(fields) {
+		repeater = path
+	}
+	if children, ok := field(fields, "children").([]interface{}); ok {
+		for idx, child := range children {
+			v.node(child, fmt.Sprintf("%s.children[%d]", path, idx), repeater)
+		}
+	}
+	if columns, ok := field(fields, "columns").([]interface{}); ok {
+		for idx, column := range columns {
+			v.column(column, fmt.Sprintf("%s.columns[%d]", path, idx))
+		}
+	}
+}
+
+// property checks the json type of a value, and then its rule.
+// Nodes and columns are checked by the caller.
+func (v *validator) property(value interface{}, path string, prop property, rule propertyRule) {
+
+	if value == nil {
+		return
+	}
+	if !prop.accepts(value) {
+		v.add(path, "Expected %s, got %s", prop.describe(), jsonTypeName(value))
+		return
+	}
+	if items, ok := value.([]interface{}); ok && prop.items != "" {
+		for idx, item := range items {
+			if jsonTypeName(item) != prop.items {
+				v.add(fmt.Sprintf("%s[%d]", path, idx), "Expected %s, got %s", prop.items, jsonTypeName(item))
+			}
+		}
+		return
+	}
+
+	if number, ok := value.(float64); ok && rule.minimum != nil && number < *rule.minimum {
+		v.add(path, "Expected %s of at least %v, got %v", prop.name, *rule.minimum, number)
+		return
+	}
+	s, ok := value.(string)
+	if !ok {
+		return
+	}
+	if rule.check != nil {
+		if msg := rule.check(s); msg != "" {
+			v.add(path, "%s", msg)
+		}
+		return
+	}
+	if len(rule.enum) > 0 && !prop.oneOf(s, rule.enum) {
+		v.add(path, "Unknown %s %q, expected one of %s%s", prop.name, s, strings.Join(rule.enum, ", "), suggest(s, rule.enum))
+	}
+}
+
+// column checks a table column
+func (v *validator) column(value interface{}, path string) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path, "Expected a column object, got %s", jsonTypeName(value))
+		return
+	}
+	for _, key := range sortedKeys(fields) {
+		prop, known := columnProperties[key]
+		if !known {
+			v.add(path+"."+key, "Unknown column property %s%s", key, suggest(key, propertyNames(columnProperties)))
+			continue
+		}
+		v.property(fields[key], path+"."+key, prop, columnRules[key])
+	}
+}
+
+// expressions checks the template expressions of a node parse
+func (v *validator) expressions(fields map[string]interface{}, path string) {
+	for _, key := range []string{"if", "content"} {
+		text, ok := fields[key].(string)
+		if !ok || (key == "content" && !strings.Contains(text, "{{")) {
+			continue
+		}
+		if key == "if" {
+			text = "{{" + text + "}}"
+		}
+		if _, err := template.New(path).Funcs(TemplateFuncs).Parse(text); err != nil {
+			v.add(path+"."+key, "Invalid expression: %s", err.Error())
+		}
+	}
+}
+
+// id checks content can be bound to a node's id. Content is
+// bound to the first node with an id below the root, and the
+// ids of nodes in a repeater get the index of each copy added.
+func (v *validator) id(fields map[string]interface{}, path, repeater string) {
+
+	id, _ := field(fields, "id").(string)
+	if id == "" {
+		return
+	}
+	if first, ok := v.ids[id]; ok {
+		v.add(path+".id", "Duplicate id %s, content is only bound to the node at %s", id, first)
+		return
+	}
+	v.ids[id] = path
+
+	switch {
+	case repeater != "" && repeater != path:
+		v.add(path+".id", "Content can't be bound to id %s, it is renamed %s-0, %s-1 and so on in each copy made by the repeater at %s", id, id, id, repeater)
+	case path == "$" && takesContent(fields):
+		v.add(path+".id", "Content can't be bound to id %s, content is only bound to the children of the root", id)
+	}
+}
+
+// takesContent reports whether a node draws content bound to it,
+// rather than just laying out its children
+func takesContent(fields map[string]interface{}) bool {
+	nodeType, _ := field(fields, "type").(string)
+	return nodeType != "" && nodeType != "div" && nodeType != NodeTypeList
+}
+
+func isRepeater(fields map[string]interface{}) bool {
+	repeat, _ := field(fields, "repeat").(string)
+	nodeType, _ := field(fields, "type").(string)
+	return repeat != "" || nodeType == NodeTypeList
+}
+
+// field is a property of a node, matched case insensitively as
+// encoding/json does
+func field(fields map[string]interface{}, name string) interface{} {
+	if value, ok := fields[name]; ok {
+		return value
+	}
+	for key, value := range fields {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// property is a property of a template object, from the json
+// tag and type of its struct field
+type property struct {
+	name string
+	// kinds are the json types the property takes
+	kinds []string
+	// items is the json type of array items, for arrays of values
+	items string
+	// caseless properties are matched case insensitively
+	caseless bool
+}
+
+// oneOf reports whether a value is one of the values of an enum
+func (p property) oneOf(value string, enum []string) bool {
+	for _, v := range enum {
+		if v == value || (p.caseless && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	nodeProperties   = properties(reflect.TypeOf(Node{}))
+	columnProperties = properties(reflect.TypeOf(TableColumn{}))
+)
+
+func properties(t reflect.Type) map[string]property {
+	props := map[string]property{}
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		prop := property{name: name, kinds: jsonKinds(f.Type), caseless: name == "errorCorrection"}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() != reflect.Struct && f.Type.Elem().Kind() != reflect.Ptr {
+			prop.items = jsonKinds(f.Type.Elem())[0]
+		}
+		props[name] = prop
+	}
+	return props
+}
+
+// jsonKinds are the json types a go type is unmarshalled from
+func jsonKinds(t reflect.Type) []string {
+	if t == reflect.TypeOf(Dimension("")) {
+		return []string{"number", "string"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonKinds(t.Elem())
+	case reflect.String:
+		return []string{"string"}
+	case reflect.Bool:
+		return []string{"boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []string{"integer"}
+	case reflect.Float32, reflect.Float64:
+		return []string{"number"}
+	case reflect.Slice:
+		return []string{"array"}
+	case reflect.Struct, reflect.Map:
+		return []string{"object"}
+	}
+	return nil
+}
+
+// accepts reports whether a value has one of the property's
+// json types. Any type is accepted by properties without types.
+func (p property) accepts(value interface{}) bool {
+	if len(p.kinds) == 0 {
+		return true
+	}
+	kind := jsonTypeName(value)
+	for _, k := range p.kinds {
+		if k == kind || (k == "number" && kind == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p property) describe() string {
+	if p.items != "" {
+		return "an array of " + p.items + "s"
+	}
+	return strings.Join(p.kinds, " or ")
+}
+
+// jsonTypeName is the json schema type of a decoded json value
+func jsonTypeName(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == float64(int64(x)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// propertyName is the property a key matches case insensitively
+func propertyName(props map[string]property, key string) string {
+	for name := range props {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+func propertyNames(props map[string]property) (names []string) {
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// suggest returns a hint naming the closest option to a
+// mistyped value, if there's one close enough
+func suggest(value string, options []string) string {
+	best, bestDistance := "", len(value)/2+1
+	for _, option := range options {
+		if d := editDistance(strings.ToLower(value), strings.ToLower(option)); d < bestDistance {
+			best, bestDistance = option, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(fields map[string]interface{}) (keys []string) {
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// mapKeys returns the sorted keys of one of the enum maps
+func mapKeys(m interface{}) (keys []string) {
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return
+}
+
+//go:generate sh -c "go run ./cmd/epd-render schema > template.schema.json"
+
+// TemplateSchema returns a JSON Schema for template nodes, for
+// editors to complete and check templates with. It is generated
+// from the same rules as ValidateTemplate, and allows the
+// properties of TemplateLoader files too. It can't tell
+// duplicate or unreachable ids.
+func TemplateSchema() ([]byte, error) {
+
+	// Template files also use the properties of the TemplateLoader,
+	// which are gone by the time a template is validated
+	node := objectSchema(nodeProperties, nodeRule)
+	nodeOrNodes := map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "#/definitions/node"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/definitions/node"}},
+		},
+	}
+	loaderProperties := map[string]interface{}{
+		"include": map[string]interface{}{"type": "string"},
+		"slot":    map[string]interface{}{"type": "string"},
+		"slots":   map[string]interface{}{"type": "object", "additionalProperties": nodeOrNodes},
+		"class":   map[string]interface{}{"type": "string"},
+		"classes": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"$ref": "#/definitions/node"}},
+	}
+	for name, prop := range loaderProperties {
+		node["properties"].(map[string]interface{})[name] = prop
+	}
+
+	schema := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "goepd template",
+		"description": "A tree of nodes laid out with flexbox and drawn for an e-paper display",
+		"$ref":        "#/definitions/node",
+		"definitions": map[string]interface{}{
+			"node":   node,
+			"column": objectSchema(columnProperties, func(prop property) propertyRule { return columnRules[prop.name] }),
+		},
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func objectSchema(props map[string]property, rule func(property) propertyRule) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, prop := range props {
+		properties[name] = propertySchema(prop, rule(prop))
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func propertySchema(prop property, rule propertyRule) map[string]interface{} {
+	schema := map[string]interface{}{}
+	switch prop.name {
+	case "children":
+		schema["items"] = map[string]interface{}{"$ref": "#/definitions/node"}
+	case "more":
+		return map[string]interface{}{"$ref": "#/definitions/node"}
+	case "columns":
+		schema["items"] = map[string]interface{}{"$ref": "#/definitions/column"}
+	}
+	if prop.items != "" {
+		schema["items"] = map[string]interface{}{"type": prop.items}
+	}
+	switch len(prop.kinds) {
+	case 0:
+	case 1:
+		schema["type"] = prop.kinds[0]
+	default:
+		schema["type"] = prop.kinds
+	}
+	if len(rule.enum) > 0 {
+		enum := rule.enum
+		if prop.caseless {
+			enum = nil
+			for _, value := range rule.enum {
+				enum = append(enum, strings.ToLower(value))
+				if upper := strings.ToUpper(value); upper != strings.ToLower(value) {
+					enum = append(enum, upper)
+				}
+			}
+		}
+		schema["enum"] = enum
+	}
+	if rule.pattern != "" {
+		schema["pattern"] = rule.pattern
+	}
+	if rule.minimum != nil {
+		schema["minimum"] = *rule.minimum
+	}
+	return schema
+}
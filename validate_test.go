@@ -0,0 +1,139 @@
+package epd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		tpl  RenderTemplate
+		want []Problem
+	}{
+		{
+			name: "built in landscape",
+			tpl:  TplDefaulltLandscape,
+		},
+		{
+			name: "built in portrait",
+			tpl:  TplDefaultPortrait,
+		},
+		{
+			name: "unknown and miscased properties",
+			tpl:  `{"children": [{"id": "a", "Type": "text", "fontsize": 2, "colour": "red", "bogus": 1}]}`,
+			want: []Problem{
+				{"$.children[0].Type", "Property Type should be written type"},
+				{"$.children[0].bogus", "Unknown property bogus"},
+				{"$.children[0].colour", "Unknown property colour (did you mean color?)"},
+				{"$.children[0].fontsize", "Property fontsize should be written fontSize"},
+			},
+		},
+		{
+			name: "miscased properties are still checked",
+			tpl:  `{"children": [{"id": "a", "fontweight": "bolder"}]}`,
+			want: []Problem{
+				{"$.children[0].fontweight", "Property fontweight should be written fontWeight"},
+				{"$.children[0].fontweight", "Unknown fontWeight \"bolder\", expected a number from 1 to 999 or one of black, bold, book, demibold, extrabold, extralight, hairline, heavy, light, medium, normal, regular, semibold, thin, ultrabold, ultralight"},
+			},
+		},
+		{
+			name: "bad values",
+			tpl:  `{"children": [{"id": "a", "justifyContent": "space-evenly", "alignItems": "centre", "width": "10em", "color": "purple"}]}`,
+			want: []Problem{
+				{"$.children[0].alignItems", "Unknown alignItems \"centre\", expected one of auto, baseline, center, flex-end, flex-start, space-around, space-between, stretch (did you mean center?)"},
+				{"$.children[0].color", "Invalid colour \"purple\", expected black, white, red, transparent or a hex colour"},
+				{"$.children[0].justifyContent", "Unknown justifyContent \"space-evenly\", expected one of center, flex-end, flex-start, space-around, space-between (did you mean space-around?)"},
+				{"$.children[0].width", "Invalid dimension \"10em\", expected a number of pixels, a percentage or auto"},
+			},
+		},
+		{
+			name: "negative quiet zone",
+			tpl:  `{"children": [{"id": "sku", "type": "barcode", "quietZone": -23}]}`,
+			want: []Problem{
+				{"$.children[0].quietZone", "Expected quietZone of at least 0, got -23"},
+			},
+		},
+		{
+			name: "wrong json types",
+			tpl:  `{"children": [{"id": "a", "flexGrow": "1", "children": {}}]}`,
+			want: []Problem{
+				{"$.children[0].children", "Expected array, got object"},
+				{"$.children[0].flexGrow", "Expected number, got string"},
+			},
+		},
+		{
+			name: "duplicate ids",
+			tpl:  `{"children": [{"id": "a"}, {"children": [{"id": "a"}]}]}`,
+			want: []Problem{
+				{"$.children[1].children[0].id", "Duplicate id a, content is only bound to the node at $.children[0]"},
+			},
+		},
+		{
+			name: "ids in repeaters",
+			tpl: `{"children": [
+				{"id": "list", "type": "list", "children": [{"id": "item"}], "more": {"id": "more"}},
+				{"repeat": "items", "children": [{"id": "x"}]}]}`,
+			want: []Problem{
+				{"$.children[0].children[0].id", "Content can't be bound to id item, it is renamed item-0, item-1 and so on in each copy made by the repeater at $.children[0]"},
+				{"$.children[1].children[0].id", "Content can't be bound to id x, it is renamed x-0, x-1 and so on in each copy made by the repeater at $.children[1]"},
+			},
+		},
+		{
+			name: "id of the root",
+			tpl:  `{"id": "root", "type": "text"}`,
+			want: []Problem{
+				{"$.id", "Content can't be bound to id root, content is only bound to the children of the root"},
+			},
+		},
+		{
+			name: "parse error",
+			tpl:  `{"children": [`,
+			want: []Problem{
+				{"$", "unexpected end of JSON input (line 1, column 14)"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := ValidateTemplate(test.tpl)
+			if !reflect.DeepEqual(problems, test.want) {
+				t.Errorf("Expected problems %v, got %v", test.want, problems)
+			}
+		})
+	}
+}
+
+func TestTemplateSchema(t *testing.T) {
+	schema, err := TemplateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Definitions map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err = json.Unmarshal(schema, &parsed); err != nil {
+		t.Fatalf("Invalid schema: %s", err.Error())
+	}
+	enum := parsed.Definitions["node"].Properties["justifyContent"].Enum
+	if !contains(enum, "space-between") || contains(enum, "space-evenly") {
+		t.Errorf("Unexpected justifyContent values %v", enum)
+	}
+
+	// The schema in the repo is kept up to date by go generate
+	committed, err := ioutil.ReadFile("template.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(committed), schema) {
+		t.Error("template.schema.json is out of date, run go generate")
+	}
+}
@@ -0,0 +1,169 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kjk/flex"
+)
+
+// vectorCanvas is drawn on by the renderers to vector formats,
+// SVG and PDF. Text is kept as text, and any other content is
+// drawn as it would be on the panel and embedded as an image.
+type vectorCanvas interface {
+	// rect fills a box, or strokes the inside of its edge
+	// width pixels wide if width is more than 0
+	rect(box roundedRect, c color.Color, width int)
+	// text draws text in a face with its baseline starting at x, y
+	text(face textFace, c color.Color, x, y float64, text string)
+	// image draws an image over bounds
+	image(img image.Image, bounds image.Rectangle)
+	// invert inverts the colours of a box
+	invert(box roundedRect)
+	// clip clips what's drawn to bounds until unclip
+	clip(bounds image.Rectangle)
+	unclip()
+}
+
+// RenderSVG lays content out in the template as Render does,
+// and writes it as an SVG document. Text is kept as text in the
+// fonts it was laid out with, which are embedded if they're
+// TrueType fonts. Images, codes, charts, tables and svgs are
+// embedded as the images they'd be drawn as on the panel.
+func (r flexRenderEngine) RenderSVG(w io.Writer, content RenderContent, width, height int, layout RenderTemplate) (err error) {
+	flexNode, err := r.layout(content, width, height, layout)
+	if err != nil {
+		return
+	}
+	canvas := newSVGCanvas(r.fonts, width, height)
+	r.renderVector(flexNode, image.Point{}, canvas)
+	return canvas.writeTo(w)
+}
+
+// RenderPDF lays content out in the template as Render does, and
+// writes it as a single page PDF with a point for each pixel.
+// Fonts and images are embedded as for RenderSVG, but text in
+// fonts that can't be embedded is set in the closest go font.
+func (r flexRenderEngine) RenderPDF(w io.Writer, content RenderContent, width, height int, layout RenderTemplate) (err error) {
+	flexNode, err := r.layout(content, width, height, layout)
+	if err != nil {
+		return
+	}
+	canvas := newPDFCanvas(r.fonts, width, height)
+	r.renderVector(flexNode, image.Point{}, canvas)
+	return canvas.writeTo(w)
+}
+
+// renderVector draws a node and its children on a canvas,
+// as RenderNode does on an image
+func (r flexRenderEngine) renderVector(flexNode *flex.Node, offset image.Point, c vectorCanvas) {
+
+	node, _ := flexNode.Context.(*Node)
+	box := roundedRect{
+		rect:   boxRect(flexNode, offset),
+		radius: float64(node.BorderRadius),
+	}
+	rect := contentRect(flexNode, offset)
+
+	if background := ColorFromString(node.BackgroundColor); background != nil {
+		c.rect(box, background, 0)
+	}
+	if border := int(flexNode.LayoutGetBorder(flex.EdgeLeft)); border > 0 {
+		c.rect(box, node.borderColor(), border)
+	}
+
+	if text, ok := node.Content.(string); ok && node.code == nil {
+		r.vectorText(text, node.TextStyle(), rect, c)
+	} else if node.Content != nil && !rect.Empty() {
+		dst := image.NewRGBA(rect)
+		r.drawContent(node, rect, dst)
+		c.image(dst, rect)
+	}
+
+	for _, child := range flexNode.Children {
+		r.renderVector(child, box.rect.Min, c)
+	}
+
+	if node.Invert {
+		c.invert(box)
+	}
+}
+
+// vectorText draws text on a canvas where drawText would draw it
+func (r flexRenderEngine) vectorText(text string, style TextStyle, bounds image.Rectangle, c vectorCanvas) {
+
+	if text == "" {
+		return
+	}
+
+	textColor := style.Color
+	if textColor == nil {
+		textColor = ColorBlack
+	}
+
+	if style.Overflow == TextOverflowClip || style.Overflow == TextOverflowEllipsis {
+		c.clip(bounds)
+		defer c.unclip()
+	}
+
+	if style.Format == TextFormatMarkdown {
+		layout := r.layoutMarkdown(text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+		top := alignedTop(style, bounds, layout.height)
+		for _, line := range layout.lines {
+			if line.rule {
+				y := int(math.Round(top + line.top + line.height/2))
+				c.rect(roundedRect{rect: image.Rect(bounds.Min.X, y, bounds.Max.X, y+1)}, textColor, 0)
+				continue
+			}
+			x := alignedLeft(style, bounds, line.width)
+			for _, run := range line.runs {
+				c.text(run.face, textColor, x+run.x, top+line.top+line.ascent, run.text)
+			}
+		}
+		return
+	}
+
+	face := r.textFace(style)
+	layout := layoutText(face, text, style, float64(bounds.Dx()), float64(bounds.Dy()))
+	top := alignedTop(style, bounds, layout.height)
+	for idx, line := range layout.lines {
+		x := alignedLeft(style, bounds, line.width)
+		y := top + layout.ascent + float64(idx)*layout.lineHeight
+		if style.Align == TextAlignJustify && !line.paraEnd {
+			vectorJustified(c, face, textColor, line, x, y, float64(bounds.Dx()))
+		} else {
+			c.text(face, textColor, x, y, line.text)
+		}
+	}
+}
+
+// vectorJustified draws a line stretched to width as drawJustified
+// does, placing each word, or each character of lines without
+// spaces, where it would be drawn
+func vectorJustified(c vectorCanvas, face textFace, textColor color.Color, line textLine, x, y, width float64) {
+
+	pieces := strings.SplitAfter(line.text, " ")
+	if len(pieces) == 1 {
+		pieces = splitRunes(line.text)
+	}
+	if len(pieces) <= 1 {
+		c.text(face, textColor, x, y, line.text)
+		return
+	}
+	extra := (width - line.width) / float64(len(pieces)-1)
+
+	prefix := ""
+	for idx, piece := range pieces {
+		offset := face.measure(prefix) + extra*float64(idx)
+		if prefix != "" {
+			offset += face.letterSpacing
+		}
+		if text := strings.TrimRight(piece, " "); text != "" {
+			c.text(face, textColor, x+offset, y, text)
+		}
+		prefix += piece
+	}
+}